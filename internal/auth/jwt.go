@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/securecookie"
+	"github.com/tiunovvv/go-yandex-shortener/internal/config"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// TokenManager issues and verifies HS256 signed bearer tokens whose subject is
+// the user ID.
+type TokenManager struct {
+	key []byte
+	ttl time.Duration
+}
+
+func NewTokenManager(key []byte, ttl time.Duration) *TokenManager {
+	return &TokenManager{key: key, ttl: ttl}
+}
+
+// NewTokenManagerFromConfig falls back to a random key and a day long TTL when
+// they are not configured.
+func NewTokenManagerFromConfig(config *config.Config) *TokenManager {
+	const keyLength = 32
+	key := []byte(config.JWTSecret)
+	if len(key) == 0 {
+		key = securecookie.GenerateRandomKey(keyLength)
+	}
+
+	const defaultTTL = 24 * time.Hour
+	ttl := config.JWTTTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	return NewTokenManager(key, ttl)
+}
+
+func (tm *TokenManager) Issue(userID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(tm.ttl)
+	claims := jwt.RegisteredClaims{
+		Subject:   userID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tm.key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+func (tm *TokenManager) Parse(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return tm.key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse token: %w: %w", ErrInvalidToken, err)
+	}
+
+	if claims.Subject == "" {
+		return "", fmt.Errorf("token has no subject: %w", ErrInvalidToken)
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,15 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/gofrs/uuid"
+)
+
+func NewUserID() (string, error) {
+	userID, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate userID: %w", err)
+	}
+	return userID.String(), nil
+}
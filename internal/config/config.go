@@ -1,104 +1,290 @@
 package config
 
 import (
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
-	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gorilla/securecookie"
+	"github.com/tiunovvv/go-yandex-shortener/internal/idgen"
 	"go.uber.org/zap"
 )
 
 type Config struct {
-	logger        *zap.Logger
-	ServerAddress string
-	BaseURL       string
-	FilePath      string
-	DSN           string
+	logger             *zap.Logger
+	ServerAddress      string
+	GRPCAddress        string
+	BaseURL            string
+	FilePath           string
+	BoltPath           string
+	DSN                string
+	IDGenerator        string
+	IDAlphabet         string
+	IDSalt             string
+	JWTSecret          string
+	ClickIPSecret      string
+	TrustedSubnet      string
+	TLSCertFile        string
+	TLSKeyFile         string
+	TLSCacheDir        string
+	HTTPRedirect       string
+	TraceExporter      string
+	OTLPEndpoint       string
+	RedisURL           string
+	FileSync           string
+	StorageBackend     string
+	cookieSecret       string
+	cookieKeyFile      string
+	cookiePrevSecrets  []string
+	CookieKeys         [][]byte
+	IDLength           int
+	DeleteWorkers      int
+	DeleteBatchSize    int
+	CacheSize          int
+	JWTTTL             time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	RequestTimeout     time.Duration
+	ShutdownTimeout    time.Duration
+	SweepInterval      time.Duration
+	ClickFlushInterval time.Duration
+	DeleteInterval     time.Duration
+	CacheTTL           time.Duration
+	FileCompaction     time.Duration
+	EnableHTTPS        bool
 }
 
-func NewConfig(logger *zap.Logger) *Config {
-	serverAddress := flag.String("a", "localhost:8080", "server start URL")
-	baseURL := flag.String("b", "http://localhost:8080", "base of short URL")
-	filePath := flag.String("f", "tmp/short-url-db.json", "file storage path")
-	dsn := flag.String("d", "", "db adress")
-	flag.Parse()
-
-	config := Config{
-		logger:        logger,
-		ServerAddress: getServerAddress(serverAddress),
-		BaseURL:       getBaseURL(baseURL),
-		FilePath:      getFilePath(filePath),
-		DSN:           getDatabaseDsn(dsn),
+// NewConfig builds the config with precedence flags > env > config file >
+// defaults. The config file is set by -c or CONFIG and may be JSON or YAML.
+// All invalid options are reported at once.
+func NewConfig(logger *zap.Logger) (*Config, error) {
+	config, err := newConfig(flag.CommandLine, os.Args[1:], os.Getenv)
+	if err != nil {
+		return nil, err
 	}
+	config.logger = logger
 
 	logger.Sugar().Infof("server start URL: %s", config.ServerAddress)
 	logger.Sugar().Infof("base of short URL: %s", config.BaseURL)
+	if config.GRPCAddress == "" {
+		logger.Sugar().Info("gRPC server address is empty, gRPC is disabled")
+	} else {
+		logger.Sugar().Infof("gRPC server address: %s", config.GRPCAddress)
+	}
 	if config.FilePath == "" {
 		logger.Sugar().Info("file storage path is empty, disk recording is disabled")
 	}
 	if config.FilePath != "" {
 		logger.Sugar().Infof("file storage path: %s", config.FilePath)
 	}
+	if config.BoltPath != "" {
+		logger.Sugar().Infof("bolt storage path: %s", config.BoltPath)
+	}
 	logger.Sugar().Infof("database connection address: %s", config.DSN)
+	logger.Sugar().Infof("short URL generator: %s, length: %d", config.IDGenerator, config.IDLength)
+	if config.JWTSecret == "" {
+		logger.Sugar().Warn("JWT key is empty, bearer tokens will not survive a restart")
+		const keyLength = 32
+		config.JWTSecret = string(securecookie.GenerateRandomKey(keyLength))
+	}
+	if config.ClickIPSecret == "" {
+		logger.Sugar().Warn("click IP key is empty, IP hashes of clicks will differ after a restart")
+		const keyLength = 32
+		config.ClickIPSecret = string(securecookie.GenerateRandomKey(keyLength))
+	}
+	if config.EnableHTTPS {
+		logger.Sugar().Infof("HTTPS is enabled, certificate: %s", config.TLSCertFile)
+	}
+	if config.TrustedSubnet == "" {
+		logger.Sugar().Info("trusted subnet is empty, internal endpoints are disabled")
+	}
+	if len(config.CookieKeys) == 0 {
+		logger.Sugar().Warn("cookie secret is empty, sessions will not survive a restart")
+	}
+	if len(config.CookieKeys) != 0 {
+		logger.Sugar().Infof("cookie secrets: 1 current, %d previous", len(config.CookieKeys)/2-1)
+	}
 
-	return &config
+	return config, nil
 }
 
-func getServerAddress(flagServerAddress *string) string {
-	if envServerAddress := os.Getenv("SERVER_ADDRESS"); envServerAddress != "" {
-		return envServerAddress
+func newConfig(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, error) {
+	config := defaultConfig()
+
+	configFile := fs.String("c", "", "JSON or YAML config file")
+	for _, opt := range options {
+		if opt.isBool {
+			fs.Bool(opt.flag, false, opt.usage)
+			continue
+		}
+		fs.String(opt.flag, "", opt.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse flags: %w", err)
 	}
 
-	return *flagServerAddress
-}
+	errs := make([]error, 0)
 
-func getBaseURL(flagBaseURL *string) string {
-	if envBaseURL := os.Getenv("BASE_URL"); envBaseURL != "" && checkBaseURL(envBaseURL) {
-		return envBaseURL
+	path := *configFile
+	if path == "" {
+		path = getenv("CONFIG")
+	}
+	if path != "" {
+		errs = append(errs, config.loadFile(path)...)
 	}
 
-	return *flagBaseURL
-}
-
-func getFilePath(filePath *string) string {
-	if envFilePath := os.Getenv("FILE_STORAGE_PATH"); envFilePath != "" {
-		return envFilePath
+	for _, opt := range options {
+		if value := getenv(opt.env); value != "" {
+			if err := opt.set(config, value); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", opt.env, err))
+			}
+		}
 	}
 
-	return *filePath
-}
+	fs.Visit(func(f *flag.Flag) {
+		for _, opt := range options {
+			if opt.flag != f.Name {
+				continue
+			}
+			if err := opt.set(config, f.Value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", opt.flag, err))
+			}
+		}
+	})
 
-func getDatabaseDsn(databaseDsn *string) string {
-	if envDatabaseDsn := os.Getenv("DATABASE_DSN"); envDatabaseDsn != "" {
-		return envDatabaseDsn
+	errs = append(errs, config.validate()...)
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("invalid config: %w", errors.Join(errs...))
 	}
 
-	return *databaseDsn
+	return config, nil
 }
 
-func checkBaseURL(str string) bool {
-	substr := strings.Split(str, ":")
+func defaultConfig() *Config {
 	const (
-		length     = 3
-		isNotAForm = "%s is not in a form tls://host:port"
+		defaultIDLength      = 8
+		defaultDeleteWorkers = 3
+		defaultDeleteBatch   = 1000
+		defaultCacheSize     = 10000
+		defaultJWTTTL        = 24 * time.Hour
+		defaultTimeout       = 5 * time.Second
+		defaultShutdown      = 10 * time.Second
 	)
 
-	if len(substr) != length {
-		log.Printf(isNotAForm, str)
-		return false
+	return &Config{
+		ServerAddress:      "localhost:8080",
+		BaseURL:            "http://localhost:8080",
+		FilePath:           "tmp/short-url-db.json",
+		IDGenerator:        "random",
+		TLSCacheDir:        "tmp/tls",
+		TraceExporter:      "none",
+		FileSync:           "always",
+		StorageBackend:     "auto",
+		FileCompaction:     time.Minute,
+		IDLength:           defaultIDLength,
+		DeleteWorkers:      defaultDeleteWorkers,
+		DeleteBatchSize:    defaultDeleteBatch,
+		DeleteInterval:     time.Second,
+		CacheSize:          defaultCacheSize,
+		CacheTTL:           time.Minute,
+		JWTTTL:             defaultJWTTTL,
+		ReadTimeout:        defaultTimeout,
+		WriteTimeout:       defaultTimeout,
+		RequestTimeout:     defaultTimeout,
+		ShutdownTimeout:    defaultShutdown,
+		SweepInterval:      time.Minute,
+		ClickFlushInterval: defaultTimeout,
+	}
+}
+
+func (c *Config) validate() []error {
+	errs := make([]error, 0)
+
+	if baseURL, err := url.Parse(c.BaseURL); err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		errs = append(errs, fmt.Errorf("base URL %q is not in a form scheme://host[:port]", c.BaseURL))
 	}
 
-	if _, err := strconv.Atoi(substr[2]); err != nil {
-		log.Printf("Port %s must have 4 digits", substr[1])
-		return false
+	if _, _, err := net.SplitHostPort(c.ServerAddress); err != nil {
+		errs = append(errs, fmt.Errorf("server address %q is not in a form host:port", c.ServerAddress))
 	}
 
-	if idx := strings.Index(substr[1], "//"); idx < 0 {
-		log.Printf(isNotAForm, str)
-		return false
+	if c.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.TrustedSubnet); err != nil {
+			errs = append(errs, fmt.Errorf("trusted subnet %q is not a CIDR", c.TrustedSubnet))
+		}
 	}
 
-	return true
+	if c.CacheSize < 0 {
+		errs = append(errs, errors.New("cache size must not be negative"))
+	}
+
+	switch c.TraceExporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("trace exporter %q is not one of none, stdout, otlp", c.TraceExporter))
+	}
+
+	switch c.StorageBackend {
+	case "auto", "postgres", "bolt", "file", "memory":
+	default:
+		errs = append(errs, fmt.Errorf("storage backend %q is not one of auto, postgres, bolt, file, memory",
+			c.StorageBackend))
+	}
+
+	switch c.FileSync {
+	case "always", "interval", "never":
+	default:
+		errs = append(errs, fmt.Errorf("file sync %q is not one of always, interval, never", c.FileSync))
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS certificate and key must be set together"))
+	}
+
+	positive := map[string]int64{
+		"id length":            int64(c.IDLength),
+		"delete workers":       int64(c.DeleteWorkers),
+		"delete batch size":    int64(c.DeleteBatchSize),
+		"delete interval":      int64(c.DeleteInterval),
+		"cache TTL":            int64(c.CacheTTL),
+		"JWT TTL":              int64(c.JWTTTL),
+		"read timeout":         int64(c.ReadTimeout),
+		"write timeout":        int64(c.WriteTimeout),
+		"request timeout":      int64(c.RequestTimeout),
+		"shutdown timeout":     int64(c.ShutdownTimeout),
+		"sweep interval":       int64(c.SweepInterval),
+		"click flush interval": int64(c.ClickFlushInterval),
+		"file compaction":      int64(c.FileCompaction),
+	}
+	for _, name := range sortedKeys(positive) {
+		if positive[name] <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+	if c.IDLength > idgen.MaxLength {
+		errs = append(errs, fmt.Errorf("id length %d is over %d", c.IDLength, idgen.MaxLength))
+	}
+
+	cookieKeys, err := ParseCookieKeys(c.cookieSecret, c.cookiePrevSecrets, c.cookieKeyFile)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to parse cookie keys: %w", err))
+	}
+	c.CookieKeys = cookieKeys
+
+	return errs
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name string, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestNewConfigPrecedence(t *testing.T) {
+	jsonFile := writeConfigFile(t, "config.json", `{
+		"server_address": "file:1",
+		"base_url": "http://file:1",
+		"database_dsn": "postgres://file",
+		"id_length": 10,
+		"delete_workers": 7,
+		"request_timeout": "3s",
+		"enable_https": true,
+		"cookie_previous_secrets": []
+	}`)
+	yamlFile := writeConfigFile(t, "config.yaml", "server_address: yaml:1\nid_length: 12\n")
+
+	tests := []struct {
+		env  map[string]string
+		want func(t *testing.T, c *Config)
+		name string
+		args []string
+	}{
+		{
+			name: "defaults",
+			want: func(t *testing.T, c *Config) {
+				t.Helper()
+				assert.Equal(t, "localhost:8080", c.ServerAddress)
+				assert.Equal(t, 8, c.IDLength)
+				assert.Equal(t, 5*time.Second, c.RequestTimeout)
+				assert.False(t, c.EnableHTTPS)
+				assert.Equal(t, "auto", c.StorageBackend)
+				assert.Empty(t, c.GRPCAddress, "gRPC is disabled by default")
+			},
+		},
+		{
+			name: "file over defaults",
+			args: []string{"-c", jsonFile},
+			want: func(t *testing.T, c *Config) {
+				t.Helper()
+				assert.Equal(t, "file:1", c.ServerAddress)
+				assert.Equal(t, "postgres://file", c.DSN)
+				assert.Equal(t, 10, c.IDLength)
+				assert.Equal(t, 7, c.DeleteWorkers)
+				assert.Equal(t, 3*time.Second, c.RequestTimeout)
+				assert.True(t, c.EnableHTTPS)
+				assert.Equal(t, "tmp/short-url-db.json", c.FilePath)
+			},
+		},
+		{
+			name: "yaml file from env",
+			env:  map[string]string{"CONFIG": yamlFile},
+			want: func(t *testing.T, c *Config) {
+				t.Helper()
+				assert.Equal(t, "yaml:1", c.ServerAddress)
+				assert.Equal(t, 12, c.IDLength)
+			},
+		},
+		{
+			name: "env over file",
+			args: []string{"-c", jsonFile},
+			env:  map[string]string{"SERVER_ADDRESS": "env:1", "ENABLE_HTTPS": "false"},
+			want: func(t *testing.T, c *Config) {
+				t.Helper()
+				assert.Equal(t, "env:1", c.ServerAddress)
+				assert.False(t, c.EnableHTTPS)
+				assert.Equal(t, "postgres://file", c.DSN)
+			},
+		},
+		{
+			name: "flags over env",
+			args: []string{"-c", jsonFile, "-a", "flag:1", "-s"},
+			env:  map[string]string{"SERVER_ADDRESS": "env:1", "ENABLE_HTTPS": "false"},
+			want: func(t *testing.T, c *Config) {
+				t.Helper()
+				assert.Equal(t, "flag:1", c.ServerAddress)
+				assert.True(t, c.EnableHTTPS)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			config, err := newConfig(fs, tt.args, func(key string) string { return tt.env[key] })
+			require.NoError(t, err)
+			tt.want(t, config)
+		})
+	}
+}
+
+func TestNewConfigReportsAllErrors(t *testing.T) {
+	jsonFile := writeConfigFile(t, "config.json", `{"id_lenght": 10, "delete_workers": "many"}`)
+	env := map[string]string{
+		"BASE_URL":        "localhost",
+		"TRUSTED_SUBNET":  "10.0.0.1",
+		"JWT_TTL":         "day",
+		"STORAGE_BACKEND": "mongo",
+		"ID_LENGTH":       "65",
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := newConfig(fs, []string{"-c", jsonFile, "-tls-cert", "cert.pem"}, func(key string) string { return env[key] })
+	require.Error(t, err)
+
+	for _, msg := range []string{
+		`unknown option "id_lenght"`,
+		`delete_workers: "many" is not a number`,
+		`env JWT_TTL: "day" is not a duration`,
+		`base URL "localhost"`,
+		`trusted subnet "10.0.0.1" is not a CIDR`,
+		"TLS certificate and key must be set together",
+		`storage backend "mongo" is not one of auto, postgres, bolt, file, memory`,
+		"id length 65 is over 64",
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"crypto/aes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	minHashKeyLength = 32
+	keyPairSeparator = ":"
+)
+
+var ErrInvalidCookieKey = errors.New("invalid cookie key")
+
+// ParseCookieKeys turns the configured cookie secrets into the hash/block key
+// pairs expected by securecookie. Every secret is "hashKey[:blockKey]" with
+// both parts base64 encoded. The first secret signs new cookies, the others
+// are only used to verify cookies issued before a rotation. Secrets from the
+// key file, one per line, go after the explicitly passed ones.
+func ParseCookieKeys(current string, previous []string, keyFile string) ([][]byte, error) {
+	secrets := make([]string, 0, len(previous)+1)
+	if current != "" {
+		secrets = append(secrets, current)
+	}
+	for _, secret := range previous {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	if keyFile != "" {
+		fileSecrets, err := readCookieKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, fileSecrets...)
+	}
+
+	keys := make([][]byte, 0, len(secrets)*2)
+	for i, secret := range secrets {
+		hashKey, blockKey, err := parseCookieSecret(secret)
+		if err != nil {
+			return nil, fmt.Errorf("cookie secret #%d: %w", i+1, err)
+		}
+		keys = append(keys, hashKey, blockKey)
+	}
+
+	return keys, nil
+}
+
+func readCookieKeyFile(keyFile string) ([]string, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookie key file %s: %w", keyFile, err)
+	}
+
+	secrets := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		secrets = append(secrets, line)
+	}
+	return secrets, nil
+}
+
+func parseCookieSecret(secret string) ([]byte, []byte, error) {
+	hashPart, blockPart, _ := strings.Cut(secret, keyPairSeparator)
+
+	hashKey, err := base64.StdEncoding.DecodeString(hashPart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("hash key is not base64: %w", ErrInvalidCookieKey)
+	}
+	if len(hashKey) < minHashKeyLength {
+		return nil, nil, fmt.Errorf("hash key must have at least %d bytes: %w", minHashKeyLength, ErrInvalidCookieKey)
+	}
+
+	if blockPart == "" {
+		return hashKey, nil, nil
+	}
+
+	blockKey, err := base64.StdEncoding.DecodeString(blockPart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("block key is not base64: %w", ErrInvalidCookieKey)
+	}
+	if _, err := aes.NewCipher(blockKey); err != nil {
+		return nil, nil, fmt.Errorf("block key must have 16, 24 or 32 bytes: %w", ErrInvalidCookieKey)
+	}
+
+	return hashKey, blockKey, nil
+}
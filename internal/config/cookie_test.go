@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCookieKeys(t *testing.T) {
+	hashKey := strings.Repeat("h", 32)
+	blockKey := strings.Repeat("b", 16)
+	oldHashKey := strings.Repeat("o", 32)
+
+	secret := base64.StdEncoding.EncodeToString([]byte(hashKey)) + ":" +
+		base64.StdEncoding.EncodeToString([]byte(blockKey))
+	oldSecret := base64.StdEncoding.EncodeToString([]byte(oldHashKey))
+
+	keyFile := filepath.Join(t.TempDir(), "cookie.keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte("# rotated keys\n\n"+oldSecret+"\n"), 0600))
+
+	tests := []struct {
+		name     string
+		current  string
+		previous []string
+		keyFile  string
+		want     [][]byte
+		wantErr  bool
+	}{
+		{
+			name: "positive test: nothing configured",
+			want: [][]byte{},
+		},
+		{
+			name:    "positive test: current key pair",
+			current: secret,
+			want:    [][]byte{[]byte(hashKey), []byte(blockKey)},
+		},
+		{
+			name:     "positive test: rotation with previous keys",
+			current:  secret,
+			previous: []string{"", oldSecret},
+			want:     [][]byte{[]byte(hashKey), []byte(blockKey), []byte(oldHashKey), nil},
+		},
+		{
+			name:    "positive test: previous keys from file",
+			current: secret,
+			keyFile: keyFile,
+			want:    [][]byte{[]byte(hashKey), []byte(blockKey), []byte(oldHashKey), nil},
+		},
+		{
+			name:    "negativ test: short hash key",
+			current: base64.StdEncoding.EncodeToString([]byte("short")),
+			wantErr: true,
+		},
+		{
+			name:    "negativ test: wrong block key length",
+			current: base64.StdEncoding.EncodeToString([]byte(hashKey)) + ":" + base64.StdEncoding.EncodeToString([]byte("b")),
+			wantErr: true,
+		},
+		{
+			name:    "negativ test: not base64",
+			current: "not base64!",
+			wantErr: true,
+		},
+		{
+			name:    "negativ test: missing key file",
+			keyFile: filepath.Join(t.TempDir(), "missing"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseCookieKeys(tt.current, tt.previous, tt.keyFile)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys)
+		})
+	}
+}
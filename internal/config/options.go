@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// option binds a Config field to its flag, env variable and config file key.
+type option struct {
+	set    func(c *Config, value string) error
+	flag   string
+	env    string
+	key    string
+	usage  string
+	isBool bool
+}
+
+var options = []option{
+	stringOption("a", "SERVER_ADDRESS", "server_address", "server start URL",
+		func(c *Config) *string { return &c.ServerAddress }),
+	stringOption("grpc-address", "GRPC_ADDRESS", "grpc_address", "gRPC server address, empty disables gRPC",
+		func(c *Config) *string { return &c.GRPCAddress }),
+	stringOption("b", "BASE_URL", "base_url", "base of short URL",
+		func(c *Config) *string { return &c.BaseURL }),
+	stringOption("storage-backend", "STORAGE_BACKEND", "storage_backend",
+		"storage backend: postgres, bolt, file, memory or auto for the first configured one",
+		func(c *Config) *string { return &c.StorageBackend }),
+	stringOption("f", "FILE_STORAGE_PATH", "file_storage_path", "file storage path",
+		func(c *Config) *string { return &c.FilePath }),
+	stringOption("file-sync", "FILE_SYNC", "file_sync",
+		"fsync of file storage: always after each write, interval once a second or never",
+		func(c *Config) *string { return &c.FileSync }),
+	durationOption("file-compaction", "FILE_COMPACTION", "file_compaction",
+		"interval of checking whether the file storage log needs compaction",
+		func(c *Config) *time.Duration { return &c.FileCompaction }),
+	stringOption("bolt-path", "BOLT_STORAGE_PATH", "bolt_storage_path", "embedded key-value storage path",
+		func(c *Config) *string { return &c.BoltPath }),
+	stringOption("d", "DATABASE_DSN", "database_dsn", "db adress",
+		func(c *Config) *string { return &c.DSN }),
+	stringOption("id-generator", "ID_GENERATOR", "id_generator", "short URL generator: random, sequence or hashids",
+		func(c *Config) *string { return &c.IDGenerator }),
+	stringOption("id-alphabet", "ID_ALPHABET", "id_alphabet", "alphabet of short URL",
+		func(c *Config) *string { return &c.IDAlphabet }),
+	stringOption("id-salt", "ID_SALT", "id_salt", "salt of hashids generator",
+		func(c *Config) *string { return &c.IDSalt }),
+	intOption("id-length", "ID_LENGTH", "id_length", "length of short URL",
+		func(c *Config) *int { return &c.IDLength }),
+	stringOption("j", "JWT_SECRET", "jwt_secret", "key for signing JWT bearer tokens",
+		func(c *Config) *string { return &c.JWTSecret }),
+	stringOption("click-ip-secret", "CLICK_IP_SECRET", "click_ip_secret",
+		"key for hashing IP addresses of clicks",
+		func(c *Config) *string { return &c.ClickIPSecret }),
+	durationOption("jwt-ttl", "JWT_TTL", "jwt_ttl", "lifetime of JWT bearer tokens",
+		func(c *Config) *time.Duration { return &c.JWTTTL }),
+	boolOption("s", "ENABLE_HTTPS", "enable_https", "serve HTTPS",
+		func(c *Config) *bool { return &c.EnableHTTPS }),
+	stringOption("tls-cert", "TLS_CERT_FILE", "tls_cert_file",
+		"TLS certificate file, self-signed one is generated when empty",
+		func(c *Config) *string { return &c.TLSCertFile }),
+	stringOption("tls-key", "TLS_KEY_FILE", "tls_key_file", "TLS private key file",
+		func(c *Config) *string { return &c.TLSKeyFile }),
+	stringOption("tls-cache-dir", "TLS_CACHE_DIR", "tls_cache_dir",
+		"directory for the generated self-signed certificate",
+		func(c *Config) *string { return &c.TLSCacheDir }),
+	stringOption("http-redirect-address", "HTTP_REDIRECT_ADDRESS", "http_redirect_address",
+		"address of HTTP listener redirecting to HTTPS",
+		func(c *Config) *string { return &c.HTTPRedirect }),
+	stringOption("t", "TRUSTED_SUBNET", "trusted_subnet", "CIDR of clients allowed to call internal endpoints",
+		func(c *Config) *string { return &c.TrustedSubnet }),
+	stringOption("k", "COOKIE_SECRET", "cookie_secret", "cookie secret in a form base64(hashKey)[:base64(blockKey)]",
+		func(c *Config) *string { return &c.cookieSecret }),
+	{
+		flag:  "cookie-previous-secrets",
+		env:   "COOKIE_PREVIOUS_SECRETS",
+		key:   "cookie_previous_secrets",
+		usage: "comma separated cookie secrets before rotation",
+		set: func(c *Config, value string) error {
+			c.cookiePrevSecrets = splitList(value)
+			return nil
+		},
+	},
+	stringOption("cookie-key-file", "COOKIE_KEY_FILE", "cookie_key_file",
+		"file with cookie secrets, one per line, current first",
+		func(c *Config) *string { return &c.cookieKeyFile }),
+	stringOption("trace-exporter", "TRACE_EXPORTER", "trace_exporter", "trace exporter: none, stdout or otlp",
+		func(c *Config) *string { return &c.TraceExporter }),
+	stringOption("otlp-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "otlp_endpoint", "host:port of OTLP/gRPC collector",
+		func(c *Config) *string { return &c.OTLPEndpoint }),
+	intOption("cache-size", "CACHE_SIZE", "cache_size", "count of redirects cached in memory, 0 disables the cache",
+		func(c *Config) *int { return &c.CacheSize }),
+	durationOption("cache-ttl", "CACHE_TTL", "cache_ttl", "lifetime of cached redirects",
+		func(c *Config) *time.Duration { return &c.CacheTTL }),
+	stringOption("redis-url", "REDIS_URL", "redis_url", "redis://host:port/db of shared redirect cache",
+		func(c *Config) *string { return &c.RedisURL }),
+	durationOption("read-timeout", "READ_TIMEOUT", "read_timeout", "HTTP server read timeout",
+		func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	durationOption("write-timeout", "WRITE_TIMEOUT", "write_timeout", "HTTP server write timeout",
+		func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	durationOption("request-timeout", "REQUEST_TIMEOUT", "request_timeout", "timeout of request handling",
+		func(c *Config) *time.Duration { return &c.RequestTimeout }),
+	durationOption("shutdown-timeout", "SHUTDOWN_TIMEOUT", "shutdown_timeout", "graceful shutdown deadline",
+		func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	durationOption("sweep-interval", "SWEEP_INTERVAL", "sweep_interval", "interval of expired links sweeping",
+		func(c *Config) *time.Duration { return &c.SweepInterval }),
+	durationOption("click-flush-interval", "CLICK_FLUSH_INTERVAL", "click_flush_interval",
+		"interval of saving buffered clicks",
+		func(c *Config) *time.Duration { return &c.ClickFlushInterval }),
+	intOption("delete-workers", "DELETE_WORKERS", "delete_workers", "count of workers deleting URLs",
+		func(c *Config) *int { return &c.DeleteWorkers }),
+	intOption("delete-batch-size", "DELETE_BATCH_SIZE", "delete_batch_size", "count of URLs deleted in one query",
+		func(c *Config) *int { return &c.DeleteBatchSize }),
+	durationOption("delete-interval", "DELETE_INTERVAL", "delete_interval", "interval of flushing queued deletions",
+		func(c *Config) *time.Duration { return &c.DeleteInterval }),
+}
+
+func stringOption(flag, env, key, usage string, field func(*Config) *string) option {
+	return option{flag: flag, env: env, key: key, usage: usage, set: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func intOption(flag, env, key, usage string, field func(*Config) *int) option {
+	return option{flag: flag, env: env, key: key, usage: usage, set: func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func boolOption(flag, env, key, usage string, field func(*Config) *bool) option {
+	return option{flag: flag, env: env, key: key, usage: usage, isBool: true, set: func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func durationOption(flag, env, key, usage string, field func(*Config) *time.Duration) option {
+	return option{flag: flag, env: env, key: key, usage: usage, set: func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,6 +3,15 @@ package errors
 import "errors"
 
 var (
-	ErrKeyAlreadyExists = errors.New("key already exists")
-	ErrURLAlreadySaved  = errors.New("full URL already saved")
+	ErrKeyAlreadyExists   = errors.New("key already exists")
+	ErrURLAlreadySaved    = errors.New("full URL already saved")
+	ErrAliasAlreadyExists = errors.New("alias already exists")
+	ErrInvalidAlias       = errors.New("invalid alias")
+	ErrInvalidExpiration  = errors.New("invalid expiration")
+	ErrInvalidTitle       = errors.New("invalid title")
+	ErrInvalidTags        = errors.New("invalid tags")
+	ErrURLExpired         = errors.New("URL expired")
+	ErrURLNotFound        = errors.New("URL not found")
+	ErrShuttingDown       = errors.New("server is shutting down")
+	ErrInvalidCursor      = errors.New("invalid cursor")
 )
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+)
+
+const exportRoute = "/api/user/urls/export"
+
+// exportWriter writes the links of an export in one of the formats.
+type exportWriter interface {
+	Begin() error
+	Write(url models.ExportedURL) error
+	End() error
+}
+
+// GetExport streams the links of the user as CSV, a JSON array or JSON Lines
+// chosen by the format query parameter, json by default.
+func (h *Handler) GetExport(c *gin.Context) {
+	userID, status := h.getUserID(c)
+	if len(userID) == 0 {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	var writer exportWriter
+	var contentType string
+	switch format {
+	case "csv":
+		writer, contentType = &csvExportWriter{writer: csv.NewWriter(c.Writer)}, "text/csv"
+	case "json":
+		writer, contentType = &jsonExportWriter{writer: c.Writer}, "application/json"
+	case "ndjson":
+		writer, contentType = &ndjsonExportWriter{encoder: json.NewEncoder(c.Writer)}, "application/x-ndjson"
+	default:
+		newErrorResponce(c, http.StatusBadRequest, "format must be csv, json or ndjson")
+		return
+	}
+
+	h.clearDeadlines(c)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+	c.Status(http.StatusOK)
+
+	err := writer.Begin()
+	if err == nil {
+		err = h.shortener.ExportURLs(c, h.config.BaseURL, userID, writer.Write)
+	}
+	if err == nil {
+		err = writer.End()
+	}
+	if err != nil {
+		// The status is sent already, the client gets a truncated export.
+		h.logger.Sugar().Errorf("failed to export urls of %s: %v", userID, err)
+		c.Abort()
+	}
+}
+
+// clearDeadlines removes the read and write deadlines of the server from a
+// long-running request.
+func (h *Handler) clearDeadlines(c *gin.Context) {
+	controller := http.NewResponseController(c.Writer)
+	if err := controller.SetReadDeadline(time.Time{}); err != nil {
+		h.logger.Sugar().Warnf("failed to clear read deadline: %v", err)
+	}
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Sugar().Warnf("failed to clear write deadline: %v", err)
+	}
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvExportWriter) Begin() error {
+	return w.write([]string{"short_url", "original_url", "created_at", "deleted", "clicks"})
+}
+
+func (w *csvExportWriter) Write(url models.ExportedURL) error {
+	var createdAt, clicks string
+	if url.CreatedAt != nil {
+		createdAt = url.CreatedAt.Format(time.RFC3339)
+	}
+	if url.Clicks != nil {
+		clicks = strconv.FormatInt(*url.Clicks, 10)
+	}
+	return w.write([]string{url.ShortURL, url.OriginalURL, createdAt, strconv.FormatBool(url.Deleted), clicks})
+}
+
+func (w *csvExportWriter) End() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
+
+func (w *csvExportWriter) write(record []string) error {
+	if err := w.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
+
+type jsonExportWriter struct {
+	writer io.Writer
+	count  int
+}
+
+func (w *jsonExportWriter) Begin() error {
+	return w.write([]byte("["))
+}
+
+func (w *jsonExportWriter) Write(url models.ExportedURL) error {
+	data, err := json.Marshal(url)
+	if err != nil {
+		return fmt.Errorf("failed to encode url: %w", err)
+	}
+	if w.count > 0 {
+		data = append([]byte(","), data...)
+	}
+	w.count++
+	return w.write(data)
+}
+
+func (w *jsonExportWriter) End() error {
+	return w.write([]byte("]"))
+}
+
+func (w *jsonExportWriter) write(data []byte) error {
+	if _, err := w.writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonExportWriter) Begin() error {
+	return nil
+}
+
+func (w *ndjsonExportWriter) Write(url models.ExportedURL) error {
+	if err := w.encoder.Encode(url); err != nil {
+		return fmt.Errorf("failed to write JSON line: %w", err)
+	}
+	return nil
+}
+
+func (w *ndjsonExportWriter) End() error {
+	return nil
+}
@@ -13,10 +13,14 @@ import (
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/securecookie"
+	"github.com/tiunovvv/go-yandex-shortener/internal/auth"
 	"github.com/tiunovvv/go-yandex-shortener/internal/config"
+	"github.com/tiunovvv/go-yandex-shortener/internal/metrics"
 	"github.com/tiunovvv/go-yandex-shortener/internal/middleware"
 	"github.com/tiunovvv/go-yandex-shortener/internal/models"
 	"github.com/tiunovvv/go-yandex-shortener/internal/shortener"
+	"github.com/tiunovvv/go-yandex-shortener/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
 
 	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
@@ -25,6 +29,8 @@ import (
 type Handler struct {
 	config    *config.Config
 	shortener *shortener.Shortener
+	tokens    *auth.TokenManager
+	metrics   *metrics.Metrics
 	logger    *zap.Logger
 }
 
@@ -32,31 +38,62 @@ func NewHandler(config *config.Config, shortener *shortener.Shortener, logger *z
 	return &Handler{
 		config:    config,
 		shortener: shortener,
+		tokens:    auth.NewTokenManagerFromConfig(config),
 		logger:    logger,
 	}
 }
 
+// SetMetrics enables instrumentation and the /metrics endpoint.
+func (h *Handler) SetMetrics(m *metrics.Metrics) {
+	h.metrics = m
+}
+
 func (h *Handler) InitRoutes() *gin.Engine {
 	router := gin.New()
+	// Handlers pass gin.Context down as context.Context, the fallback makes
+	// the request deadline and trace span reach the store.
+	router.ContextWithFallback = true
 
+	router.Use(otelgin.Middleware(tracing.ServiceName))
 	router.Use(middleware.GinGzip(h.logger))
-	router.Use(middleware.GinLogger(h.logger))
+	router.Use(middleware.GinLogger(h.logger, h.metrics))
 
-	const seconds = 5 * time.Second
-	router.Use(middleware.GinTimeOut(seconds, "timeout error"))
+	requestTimeout := h.config.RequestTimeout
+	if requestTimeout <= 0 {
+		const seconds = 5 * time.Second
+		requestTimeout = seconds
+	}
+	router.Use(middleware.GinTimeOut(requestTimeout, "timeout error", importRoute, exportRoute))
+
+	if h.metrics != nil {
+		router.GET("/metrics", gin.WrapH(h.metrics.Handler()))
+	}
 
-	const keyLength = 32
-	var cookieStore = cookie.NewStore(securecookie.GenerateRandomKey(keyLength))
+	cookieKeys := h.config.CookieKeys
+	if len(cookieKeys) == 0 {
+		const keyLength = 32
+		cookieKeys = [][]byte{securecookie.GenerateRandomKey(keyLength)}
+	}
+	var cookieStore = cookie.NewStore(cookieKeys...)
 	router.Use(sessions.Sessions("mysession", cookieStore))
+	router.Use(middleware.BearerAuth(h.tokens, h.logger))
 	router.Use(middleware.SetCookie(h.logger))
 
 	router.POST("/", h.PostHandler)
 	router.POST("/api/shorten", h.PostAPI)
 	router.POST("/api/shorten/batch", h.PostAPIBatch)
-	router.GET("/api/user/urls", h.PostAPIUserURLs)
+	router.POST("/api/auth/token", h.PostAuthToken)
+	router.POST(importRoute, h.PostImport)
+	router.GET(userURLsRoute, h.PostAPIUserURLs)
+	router.GET(exportRoute, h.GetExport)
+	router.GET("/api/user/urls/:id/stats", h.GetURLStats)
 	router.GET("/:id", h.GetHandler)
 	router.GET("/ping", h.GetPing)
-	router.DELETE("/api/user/urls", h.SetDeletedFlag)
+	router.DELETE(userURLsRoute, h.SetDeletedFlag)
+	router.PUT("/api/user/urls/:id/tags", h.PutURLTags)
+
+	internal := router.Group("/api/internal", middleware.TrustedSubnet(h.config.TrustedSubnet, h.logger))
+	internal.GET("/stats", h.GetStats)
 	return router
 }
 
@@ -85,7 +122,13 @@ func (h *Handler) PostHandler(c *gin.Context) {
 		c.AbortWithStatus(status)
 	}
 
-	shortURL, err := h.shortener.GetShortURL(c, fullURL, userID)
+	shortURL, err := h.shortener.GetShortURL(c, fullURL, userID, models.URLMeta{})
+
+	if err != nil && !errors.Is(err, myErrors.ErrURLAlreadySaved) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		h.logger.Sugar().Errorf("failed to save %s: %v", fullURL, err)
+		return
+	}
 
 	if errors.Is(err, myErrors.ErrURLAlreadySaved) {
 		c.Status(http.StatusConflict)
@@ -116,29 +159,44 @@ func (h *Handler) GetHandler(c *gin.Context) {
 		return
 	}
 
-	fmt.Println(shortURL)
-
 	fullURL, deletedFlag, err := h.shortener.GetFullURL(c, shortURL)
 
+	if errors.Is(err, myErrors.ErrURLExpired) {
+		h.metrics.Redirect("expired")
+		c.AbortWithStatus(http.StatusGone)
+		return
+	}
+
 	if err != nil {
+		h.metrics.Redirect("error")
 		newErrorResponce(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	if deletedFlag {
+		h.metrics.Redirect("deleted")
 		c.AbortWithStatus(http.StatusGone)
 		return
 	}
 
+	h.metrics.Redirect("found")
+
+	h.shortener.RecordClick(shortURL, c.Request.Referer(), c.Request.UserAgent(), c.ClientIP())
+
 	c.Writer.Header().Set("Location", fullURL)
 	c.AbortWithStatus(http.StatusTemporaryRedirect)
 }
 
+// GetPing checks the connection to the store and reports its backend.
 func (h *Handler) GetPing(c *gin.Context) {
+	resp := models.ResPing{Status: "ok", Storage: h.shortener.Backend()}
 	if err := h.shortener.CheckConnect(c); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+		h.logger.Sugar().Errorf("failed to ping store: %v", err)
+		resp.Status = "error"
+		c.AbortWithStatusJSON(http.StatusInternalServerError, resp)
+		return
 	}
-	c.AbortWithStatus(http.StatusOK)
+	c.AbortWithStatusJSON(http.StatusOK, resp)
 }
 
 func (h *Handler) PostAPI(c *gin.Context) {
@@ -157,12 +215,52 @@ func (h *Handler) PostAPI(c *gin.Context) {
 		return
 	}
 
+	expiresAt, err := shortener.ExpirationTime(req.ExpiresAt, req.TTLSeconds)
+	if err != nil {
+		newErrorResponce(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := shortener.ValidateTitle(req.Title); err != nil {
+		newErrorResponce(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tags, err := shortener.NormalizeTags(req.Tags)
+	if err != nil {
+		newErrorResponce(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	userID, status := h.getUserID(c)
 	if len(userID) == 0 {
 		c.AbortWithStatus(status)
 	}
 
-	shortURL, err := h.shortener.GetShortURL(c, fullURL, userID)
+	meta := models.URLMeta{ExpiresAt: expiresAt, Title: req.Title, Tags: tags}
+	var shortURL string
+	if req.Alias != "" {
+		shortURL, err = h.shortener.GetShortURLWithAlias(c, fullURL, req.Alias, userID, meta)
+	} else {
+		shortURL, err = h.shortener.GetShortURL(c, fullURL, userID, meta)
+	}
+
+	if errors.Is(err, myErrors.ErrInvalidAlias) {
+		newErrorResponce(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if errors.Is(err, myErrors.ErrAliasAlreadyExists) {
+		newErrorResponce(c, http.StatusConflict, err.Error())
+		return
+	}
+
+	if err != nil && !errors.Is(err, myErrors.ErrURLAlreadySaved) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		h.logger.Sugar().Errorf("failed to save %s: %v", fullURL, err)
+		return
+	}
+
 	fullShortURL := fmt.Sprintf("%s/%s", h.config.BaseURL, shortURL)
 	resp := models.ResAPI{Result: fullShortURL}
 
@@ -190,6 +288,12 @@ func (h *Handler) PostAPIBatch(c *gin.Context) {
 
 	shortURLSlice, err := h.shortener.GetShortURLBatch(c, fullURLSlice, userID)
 
+	if errors.Is(err, myErrors.ErrInvalidExpiration) || errors.Is(err, myErrors.ErrInvalidTitle) ||
+		errors.Is(err, myErrors.ErrInvalidTags) {
+		newErrorResponce(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		h.logger.Sugar().Error("failed to save list of URLs: %w", err)
@@ -203,20 +307,26 @@ func (h *Handler) PostAPIBatch(c *gin.Context) {
 	c.AbortWithStatusJSON(http.StatusCreated, shortURLSlice)
 }
 
-func (h *Handler) PostAPIUserURLs(c *gin.Context) {
+func (h *Handler) GetURLStats(c *gin.Context) {
 	userID, status := h.getUserID(c)
 	if len(userID) == 0 {
 		c.AbortWithStatus(status)
+		return
 	}
 
-	usersURLs := h.shortener.GetURLByUserID(c, h.config.BaseURL, userID)
+	stats, err := h.shortener.GetClickStats(c, userID, c.Param("id"))
+	if errors.Is(err, myErrors.ErrURLNotFound) {
+		newErrorResponce(c, http.StatusNotFound, err.Error())
+		return
+	}
 
-	if len(usersURLs) == 0 {
-		c.AbortWithStatus(http.StatusUnauthorized)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		h.logger.Sugar().Errorf("failed to get stats: %w", err)
 		return
 	}
 
-	c.AbortWithStatusJSON(http.StatusOK, usersURLs)
+	c.AbortWithStatusJSON(http.StatusOK, stats)
 }
 
 func (h *Handler) SetDeletedFlag(c *gin.Context) {
@@ -232,11 +342,47 @@ func (h *Handler) SetDeletedFlag(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 
-	h.shortener.SetDeletedFlag(c, userID, shortURLSlice)
+	if err := h.shortener.SetDeletedFlag(c, userID, shortURLSlice); err != nil {
+		h.logger.Sugar().Errorf("failed to queue deletion: %v", err)
+		if errors.Is(err, myErrors.ErrShuttingDown) {
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
 	c.AbortWithStatus(http.StatusAccepted)
 }
 
+func (h *Handler) GetStats(c *gin.Context) {
+	stats, err := h.shortener.GetStats(c)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		h.logger.Sugar().Errorf("failed to get stats: %w", err)
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, stats)
+}
+
+func (h *Handler) PostAuthToken(c *gin.Context) {
+	userID, status := h.getUserID(c)
+	if len(userID) == 0 {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	token, expiresAt, err := h.tokens.Issue(userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		h.logger.Sugar().Errorf("failed to issue token: %w", err)
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusCreated, models.ResAuthToken{Token: token, ExpiresAt: expiresAt})
+}
+
 func (h *Handler) getUserID(c *gin.Context) (string, int) {
 	userIDInterface, exists := c.Get("user_id")
 	if !exists {
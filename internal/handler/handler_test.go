@@ -3,18 +3,31 @@ package handler
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tiunovvv/go-yandex-shortener/internal/config"
+	"github.com/tiunovvv/go-yandex-shortener/internal/idgen"
+	"github.com/tiunovvv/go-yandex-shortener/internal/metrics"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
 	"github.com/tiunovvv/go-yandex-shortener/internal/shortener"
 	"github.com/tiunovvv/go-yandex-shortener/internal/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -90,7 +103,7 @@ func TestPostHandler(t *testing.T) {
 				log.Fatalf("failed to create storage: %v", err)
 				return
 			}
-			shortener := shortener.NewShortener(store, logger)
+			shortener := shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
 			handler := NewHandler(config, shortener, logger)
 
 			router := handler.InitRoutes()
@@ -179,10 +192,10 @@ func TestGetHandler(t *testing.T) {
 			const seconds = 10 * time.Second
 			ctx, cancelCtx := context.WithTimeout(context.Background(), seconds)
 			defer cancelCtx()
-			if store.SaveURL(ctx, tt.mapKey, tt.mapValue, "") != nil {
+			if store.SaveURL(ctx, tt.mapKey, tt.mapValue, "", models.URLMeta{}) != nil {
 				log.Fatal("failed to save URL")
 			}
-			shortener := shortener.NewShortener(store, logger)
+			shortener := shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
 			handler := NewHandler(config, shortener, logger)
 
 			router := handler.InitRoutes()
@@ -242,6 +255,36 @@ func TestPostApiHandler(t *testing.T) {
 				statusCode: 500,
 			},
 		},
+		{
+			name: "positive test: title",
+			post: post{
+				request: "http://localhost:8080/api/shorten",
+				body:    `{"url":"https://practicum.yandex.ru","title":"Практикум"}`,
+			},
+			want: want{
+				statusCode: 201,
+			},
+		},
+		{
+			name: "negativ test: title with control characters",
+			post: post{
+				request: "http://localhost:8080/api/shorten",
+				body:    `{"url":"https://practicum.yandex.ru","title":"line\nbreak"}`,
+			},
+			want: want{
+				statusCode: 400,
+			},
+		},
+		{
+			name: "negativ test: batch title too long",
+			post: post{
+				request: "http://localhost:8080/api/shorten/batch",
+				body:    `[{"correlation_id": "1","original_url": "yandex.ru","title":"` + strings.Repeat("a", 257) + `"}]`,
+			},
+			want: want{
+				statusCode: 400,
+			},
+		},
 		{
 			name: "positive test several URLS",
 			post: post{
@@ -278,7 +321,7 @@ func TestPostApiHandler(t *testing.T) {
 				log.Fatalf("failed to create storage: %v", err)
 				return
 			}
-			shortener := shortener.NewShortener(store, logger)
+			shortener := shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
 			handler := NewHandler(config, shortener, logger)
 
 			router := handler.InitRoutes()
@@ -291,3 +334,1033 @@ func TestPostApiHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestPostAPIAlias(t *testing.T) {
+	type want struct {
+		statusCode int
+		body       string
+	}
+	tests := []struct {
+		name string
+		body string
+		want want
+	}{
+		{
+			name: "positive test: custom alias",
+			body: `{"url":"https://practicum.yandex.ru","alias":"spring-sale"}`,
+			want: want{
+				statusCode: 201,
+				body:       "/spring-sale",
+			},
+		},
+		{
+			name: "negativ test: alias is taken",
+			body: `{"url":"https://yandex.ru","alias":"spring-sale"}`,
+			want: want{
+				statusCode: 409,
+				body:       "alias already exists",
+			},
+		},
+		{
+			name: "negativ test: reserved alias",
+			body: `{"url":"https://google.ru","alias":"Ping"}`,
+			want: want{
+				statusCode: 400,
+				body:       "reserved",
+			},
+		},
+		{
+			name: "negativ test: alias of the metrics route",
+			body: `{"url":"https://google.ru","alias":"metrics"}`,
+			want: want{
+				statusCode: 400,
+				body:       "reserved",
+			},
+		},
+		{
+			name: "negativ test: forbidden character",
+			body: `{"url":"https://google.ru","alias":"spring/sale"}`,
+			want: want{
+				statusCode: 400,
+				body:       "forbidden character",
+			},
+		},
+	}
+
+	config := &config.Config{
+		BaseURL:       "http://localhost:8080",
+		ServerAddress: "localhost:8080",
+	}
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.NewStore(context.Background(), config, logger)
+	require.NoError(t, err)
+	router := NewHandler(config, shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger), logger).InitRoutes()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten",
+				bytes.NewReader([]byte(tt.body)))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+			assert.Contains(t, string(body), tt.want.body)
+		})
+	}
+}
+
+func TestGetHandlerExpired(t *testing.T) {
+	config := &config.Config{
+		BaseURL:       "http://localhost:8080",
+		ServerAddress: "localhost:8080",
+	}
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.NewStore(context.Background(), config, logger)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "expired1", "http://www.yandex.ru", "", models.URLMeta{}))
+	require.NoError(t, store.SetExpiration(ctx, "expired1", time.Now().Add(-time.Second)))
+	require.NoError(t, store.SaveURL(ctx, "expired2", "http://www.google.ru", "", models.URLMeta{}))
+	require.NoError(t, store.SetExpiration(ctx, "expired2", time.Now().Add(time.Hour)))
+
+	router := NewHandler(config, shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger), logger).InitRoutes()
+
+	tests := []struct {
+		name       string
+		request    string
+		statusCode int
+	}{
+		{name: "negativ test: link expired", request: "http://localhost:8080/expired1", statusCode: 410},
+		{name: "positive test: link not expired yet", request: "http://localhost:8080/expired2", statusCode: 307},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.request, nil))
+			result := w.Result()
+			require.NoError(t, result.Body.Close())
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten",
+		bytes.NewReader([]byte(`{"url":"https://ya.ru","expires_at":"2000-01-01T00:00:00Z"}`)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	result := w.Result()
+	require.NoError(t, result.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+}
+
+func TestGetURLStats(t *testing.T) {
+	config := &config.Config{
+		BaseURL:       "http://localhost:8080",
+		ServerAddress: "localhost:8080",
+	}
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.NewStore(context.Background(), config, logger)
+	require.NoError(t, err)
+	shortener := shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
+	router := NewHandler(config, shortener, logger).InitRoutes()
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		shortener.RunClickRecorder(ctx, time.Hour)
+	}()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten",
+		bytes.NewReader([]byte(`{"url":"https://practicum.yandex.ru","alias":"stats"}`))))
+	result := w.Result()
+	require.NoError(t, result.Body.Close())
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	cookies := result.Cookies()
+
+	const clicks = 3
+	for i := 0; i < clicks; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost:8080/stats", nil))
+		require.NoError(t, w.Result().Body.Close())
+	}
+
+	cancelCtx()
+	<-done
+
+	tests := []struct {
+		name       string
+		request    string
+		body       string
+		withCookie bool
+		statusCode int
+	}{
+		{
+			name:       "positive test: owner gets stats",
+			request:    "http://localhost:8080/api/user/urls/stats/stats",
+			withCookie: true,
+			statusCode: 200,
+			body:       `"total":3`,
+		},
+		{
+			name:       "negativ test: foreign user",
+			request:    "http://localhost:8080/api/user/urls/stats/stats",
+			statusCode: 404,
+		},
+		{
+			name:       "negativ test: unknown URL",
+			request:    "http://localhost:8080/api/user/urls/unknown/stats",
+			withCookie: true,
+			statusCode: 404,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.request, nil)
+			if tt.withCookie {
+				for _, cookie := range cookies {
+					request.AddCookie(cookie)
+				}
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+			assert.Contains(t, string(body), tt.body)
+		})
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	config := &config.Config{
+		BaseURL:       "http://localhost:8080",
+		ServerAddress: "localhost:8080",
+		JWTSecret:     "secret",
+		JWTTTL:        time.Hour,
+	}
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.NewStore(context.Background(), config, logger)
+	require.NoError(t, err)
+	shortener := shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
+	router := NewHandler(config, shortener, logger).InitRoutes()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/auth/token", nil))
+	result := w.Result()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	var token models.ResAuthToken
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&token))
+	require.NoError(t, result.Body.Close())
+	require.NotEmpty(t, token.Token)
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten",
+		bytes.NewReader([]byte(`{"url":"https://practicum.yandex.ru"}`)))
+	request.Header.Set("Authorization", "Bearer "+token.Token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	result = w.Result()
+	require.NoError(t, result.Body.Close())
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	assert.Empty(t, result.Cookies())
+
+	tests := []struct {
+		name          string
+		authorization string
+		body          string
+		statusCode    int
+	}{
+		{
+			name:          "positive test: token owner sees URLs",
+			authorization: "Bearer " + token.Token,
+			statusCode:    200,
+			body:          "https://practicum.yandex.ru",
+		},
+		{
+			name:          "negativ test: invalid token",
+			authorization: "Bearer invalid",
+			statusCode:    401,
+		},
+		{
+			name:       "negativ test: no token",
+			statusCode: 401,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+			assert.Contains(t, string(body), tt.body)
+		})
+	}
+}
+
+func TestCookieKeyRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	store, err := storage.NewStore(context.Background(), &config.Config{}, logger)
+	require.NoError(t, err)
+	shortener := shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
+
+	newRouter := func(keys ...[]byte) http.Handler {
+		config := &config.Config{BaseURL: "http://localhost:8080", CookieKeys: keys}
+		return NewHandler(config, shortener, logger).InitRoutes()
+	}
+
+	w := httptest.NewRecorder()
+	newRouter(oldKey, nil).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten",
+		bytes.NewReader([]byte(`{"url":"https://practicum.yandex.ru"}`))))
+	result := w.Result()
+	require.NoError(t, result.Body.Close())
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	cookies := result.Cookies()
+	require.NotEmpty(t, cookies)
+
+	tests := []struct {
+		name       string
+		router     http.Handler
+		statusCode int
+	}{
+		{name: "positive test: same key after restart", router: newRouter(oldKey, nil), statusCode: 200},
+		{name: "positive test: rotated key still verifies", router: newRouter(newKey, nil, oldKey, nil), statusCode: 200},
+		{name: "negativ test: unknown key", router: newRouter(newKey, nil), statusCode: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls", nil)
+			for _, cookie := range cookies {
+				request.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			tt.router.ServeHTTP(w, request)
+			result := w.Result()
+			require.NoError(t, result.Body.Close())
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+		})
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store := storage.NewMemory()
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "stats001", "http://www.yandex.ru", "user1", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "stats002", "http://www.google.ru", "user1", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "stats003", "http://www.ya.ru", "user2", models.URLMeta{}))
+	shortener := shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
+
+	tests := []struct {
+		name          string
+		trustedSubnet string
+		realIP        string
+		body          string
+		statusCode    int
+	}{
+		{
+			name:          "positive test: IP in trusted subnet",
+			trustedSubnet: "192.168.1.0/24",
+			realIP:        "192.168.1.10",
+			statusCode:    200,
+			body:          `{"delete_queue":{"depth":0,"deleted":0,"failed":0,"batches":0},"urls":3,"users":2}`,
+		},
+		{
+			name:          "negativ test: IP outside trusted subnet",
+			trustedSubnet: "192.168.1.0/24",
+			realIP:        "10.0.0.1",
+			statusCode:    403,
+		},
+		{
+			name:          "negativ test: no X-Real-IP",
+			trustedSubnet: "192.168.1.0/24",
+			statusCode:    403,
+		},
+		{
+			name:       "negativ test: trusted subnet is empty",
+			realIP:     "192.168.1.10",
+			statusCode: 403,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &config.Config{BaseURL: "http://localhost:8080", TrustedSubnet: tt.trustedSubnet}
+			router := NewHandler(config, shortener, logger).InitRoutes()
+
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/internal/stats", nil)
+			if tt.realIP != "" {
+				request.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			result := w.Result()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+			assert.Contains(t, string(body), tt.body)
+		})
+	}
+}
+
+func TestGetPing(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	closed, err := storage.NewBolt(filepath.Join(t.TempDir(), "urls.db"), logger)
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	tests := []struct {
+		store      storage.Store
+		name       string
+		body       string
+		statusCode int
+	}{
+		{
+			name:       "positive test: memory",
+			store:      storage.NewMemory(),
+			statusCode: 200,
+			body:       `{"status":"ok","storage":"memory"}`,
+		},
+		{
+			name:       "negativ test: store is closed",
+			store:      closed,
+			statusCode: 500,
+			body:       `{"status":"error","storage":"bolt"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shortener := shortener.NewShortener(tt.store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
+			shortener.SetBackend(storage.BackendOf(tt.store))
+			router := NewHandler(&config.Config{BaseURL: "http://localhost:8080"}, shortener, logger).InitRoutes()
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost:8080/ping", nil))
+			result := w.Result()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+			assert.JSONEq(t, tt.body, string(body))
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	config := &config.Config{
+		BaseURL:       "http://localhost:8080",
+		ServerAddress: "localhost:8080",
+	}
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	m := metrics.New()
+	store := storage.NewInstrumented(storage.NewMemory(), m)
+	require.NoError(t, store.SaveURL(context.Background(), "metrics1", "http://www.yandex.ru", "", models.URLMeta{}))
+
+	handler := NewHandler(config, shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger), logger)
+	handler.SetMetrics(m)
+	router := handler.InitRoutes()
+
+	for _, request := range []string{"http://localhost:8080/metrics1", "http://localhost:8080/unknown"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, request, nil))
+		require.NoError(t, w.Result().Body.Close())
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost:8080/metrics", nil))
+	result := w.Result()
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	require.NoError(t, result.Body.Close())
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+
+	for _, metric := range []string{
+		`shortener_http_requests_total{code="307",method="GET",route="/:id"} 1`,
+		`shortener_http_requests_total{code="400",method="GET",route="/:id"} 1`,
+		`shortener_http_request_duration_seconds_count{method="GET",route="/:id"} 2`,
+		`shortener_redirects_total{result="found"} 1`,
+		`shortener_redirects_total{result="error"} 1`,
+		`shortener_store_operation_duration_seconds_count{method="GetFullURL",result="ok"} 1`,
+		`shortener_store_operation_duration_seconds_count{method="GetFullURL",result="error"} 1`,
+	} {
+		assert.Contains(t, string(body), metric)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+
+	config := &config.Config{
+		BaseURL:       "http://localhost:8080",
+		ServerAddress: "localhost:8080",
+	}
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	memory := storage.NewMemory()
+	require.NoError(t, memory.SaveURL(context.Background(), "traced1", "http://www.yandex.ru", "", models.URLMeta{}))
+	store := storage.NewInstrumented(memory, nil)
+
+	router := NewHandler(config, shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger), logger).InitRoutes()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/traced1", nil)
+	request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	require.NoError(t, w.Result().Body.Close())
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Result().StatusCode)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, traceID, span.SpanContext().TraceID().String(), span.Name())
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "/:id")
+	require.Contains(t, spans, "middleware.timeout")
+	require.Contains(t, spans, "shortener.GetFullURL")
+	require.Contains(t, spans, "store.GetFullURL")
+	assert.Equal(t, spans["/:id"].SpanContext().SpanID(), spans["middleware.timeout"].Parent().SpanID())
+	assert.Equal(t, spans["middleware.timeout"].SpanContext().SpanID(), spans["shortener.GetFullURL"].Parent().SpanID())
+	assert.Equal(t, spans["shortener.GetFullURL"].SpanContext().SpanID(), spans["store.GetFullURL"].Parent().SpanID())
+}
+
+// newTestRouter returns the routes of a handler over a new Memory store.
+func newTestRouter(t *testing.T) (http.Handler, *shortener.Shortener, storage.Store) {
+	t.Helper()
+	config := &config.Config{
+		BaseURL:       "http://localhost:8080",
+		ServerAddress: "localhost:8080",
+	}
+	logger := zap.NewNop()
+	store := storage.NewMemory()
+	sh := shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
+	return NewHandler(config, sh, logger).InitRoutes(), sh, store
+}
+
+// createLinks shortens every body by POST /api/shorten as the same user and
+// returns the cookies of the user. The links are created a millisecond apart,
+// so their creation times differ.
+func createLinks(t *testing.T, router http.Handler, bodies ...string) []*http.Cookie {
+	t.Helper()
+	var cookies []*http.Cookie
+	for _, body := range bodies {
+		request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten",
+			bytes.NewReader([]byte(body)))
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		result := w.Result()
+		require.NoError(t, result.Body.Close())
+		require.Equal(t, http.StatusCreated, result.StatusCode, body)
+		if cookies == nil {
+			cookies = result.Cookies()
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return cookies
+}
+
+func TestPostImport(t *testing.T) {
+	router, _, store := newTestRouter(t)
+	require.NoError(t, store.SaveURL(context.Background(), "saved1", "http://saved.ru", "other", models.URLMeta{}))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []models.ImportResult
+		statusCode  int
+	}{
+		{
+			name:        "positive test: csv",
+			contentType: "text/csv",
+			body: "original_url,alias\n" +
+				"http://csv1.ru\n" +
+				"http://csv2.ru,csv-alias\n" +
+				"not url\n" +
+				"http://csv3.ru,bad alias\n" +
+				"http://csv1.ru\n" +
+				"http://saved.ru\n" +
+				"http://csv4.ru,csv-alias\n" +
+				"http://csv5.ru,a,b\n" +
+				"http://" + strings.Repeat("long", 50) + ".ru\n" +
+				"http://csv6.ru\n",
+			statusCode: http.StatusOK,
+			want: []models.ImportResult{
+				{Line: 2, Status: "created"},
+				{Line: 3, Status: "created", ShortURL: "http://localhost:8080/csv-alias"},
+				{Line: 4, Status: "invalid"},
+				{Line: 5, Status: "invalid"},
+				{Line: 6, Status: "conflict"},
+				{Line: 7, Status: "conflict", ShortURL: "http://localhost:8080/saved1"},
+				{Line: 8, Status: "conflict"},
+				{Line: 9, Status: "invalid"},
+				{Line: 10, Status: "invalid"},
+				{Line: 11, Status: "created"},
+			},
+		},
+		{
+			name:        "positive test: json lines",
+			contentType: "application/x-ndjson",
+			body: `{"original_url":"http://jsonl1.ru","alias":"jsonl-alias"}` + "\n" +
+				"\n" +
+				`{"original_url":` + "\n" +
+				`{"original_url":"http://jsonl2.ru","alias":"csv-alias"}` + "\n",
+			statusCode: http.StatusOK,
+			want: []models.ImportResult{
+				{Line: 1, Status: "created", ShortURL: "http://localhost:8080/jsonl-alias"},
+				{Line: 3, Status: "invalid"},
+				{Line: 4, Status: "conflict"},
+			},
+		},
+		{
+			name:        "negativ test: unsupported content type",
+			contentType: "text/plain",
+			body:        "http://plain.ru",
+			statusCode:  http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "http://localhost:8080/api/import",
+				bytes.NewReader([]byte(tt.body)))
+			request.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			result := w.Result()
+			require.NoError(t, result.Body.Close())
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.want == nil {
+				return
+			}
+
+			decoder := json.NewDecoder(w.Body)
+			for _, want := range tt.want {
+				var got models.ImportResult
+				require.NoError(t, decoder.Decode(&got))
+				assert.Equal(t, want.Line, got.Line)
+				assert.Equal(t, want.Status, got.Status, got.Error)
+				if want.ShortURL != "" {
+					assert.Equal(t, want.ShortURL, got.ShortURL)
+				}
+				if got.Status == "invalid" {
+					assert.NotEmpty(t, got.Error)
+				}
+			}
+			assert.False(t, decoder.More())
+		})
+	}
+}
+
+// exportTime matches the creation times of exported links, which differ
+// between runs.
+var exportTime = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T[0-9:.]+(Z|[+-]\d{2}:\d{2})`)
+
+func TestGetExport(t *testing.T) {
+	router, _, store := newTestRouter(t)
+	cookies := createLinks(t, router,
+		`{"url":"https://practicum.yandex.ru","alias":"export1"}`,
+		`{"url":"https://ya.ru","alias":"export2"}`,
+	)
+	require.NoError(t, store.SaveURL(context.Background(), "foreign", "https://foreign.ru", "other", models.URLMeta{}))
+
+	tests := []struct {
+		name        string
+		format      string
+		contentType string
+		body        string
+		statusCode  int
+	}{
+		{
+			name:        "positive test: csv",
+			format:      "csv",
+			contentType: "text/csv",
+			statusCode:  http.StatusOK,
+			body: "short_url,original_url,created_at,deleted,clicks\n" +
+				"http://localhost:8080/export1,https://practicum.yandex.ru,<time>,false,0\n" +
+				"http://localhost:8080/export2,https://ya.ru,<time>,false,0\n",
+		},
+		{
+			name:        "positive test: json",
+			format:      "json",
+			contentType: "application/json",
+			statusCode:  http.StatusOK,
+			body: `[{"created_at":"<time>","clicks":0,"short_url":"http://localhost:8080/export1",` +
+				`"original_url":"https://practicum.yandex.ru","deleted":false},{"created_at":"<time>","clicks":0,` +
+				`"short_url":"http://localhost:8080/export2","original_url":"https://ya.ru","deleted":false}]`,
+		},
+		{
+			name:        "positive test: ndjson",
+			format:      "ndjson",
+			contentType: "application/x-ndjson",
+			statusCode:  http.StatusOK,
+			body: `{"created_at":"<time>","clicks":0,"short_url":"http://localhost:8080/export1",` +
+				`"original_url":"https://practicum.yandex.ru","deleted":false}` + "\n" +
+				`{"created_at":"<time>","clicks":0,"short_url":"http://localhost:8080/export2",` +
+				`"original_url":"https://ya.ru","deleted":false}` + "\n",
+		},
+		{
+			name:       "negativ test: unknown format",
+			format:     "xml",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls/export?format="+tt.format, nil)
+			for _, cookie := range cookies {
+				request.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			result := w.Result()
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.contentType, result.Header.Get("Content-Type"))
+			assert.Equal(t, tt.body, exportTime.ReplaceAllString(string(body), "<time>"))
+		})
+	}
+}
+
+func TestPostAPIUserURLsPages(t *testing.T) {
+	router, sh, store := newTestRouter(t)
+	sh.Start()
+	cookies := createLinks(t, router,
+		`{"url":"https://practicum.yandex.ru","alias":"page1","title":"Practicum"}`,
+		`{"url":"https://ya.ru","alias":"page2"}`,
+		`{"url":"https://mail.yandex.ru/inbox","alias":"page3"}`,
+		`{"url":"https://google.com","alias":"page4"}`,
+	)
+	require.NoError(t, store.SaveURL(context.Background(), "foreign", "https://ya.ru/foreign", "other", models.URLMeta{}))
+
+	request := httptest.NewRequest(http.MethodDelete, "http://localhost:8080/api/user/urls",
+		bytes.NewReader([]byte(`["page4"]`)))
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	require.NoError(t, w.Result().Body.Close())
+	require.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	require.NoError(t, sh.Stop(context.Background()))
+
+	get := func(t *testing.T, target string) (*http.Response, []string) {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		result := w.Result()
+		defer func() { require.NoError(t, result.Body.Close()) }()
+
+		var urls []models.UsersURLs
+		if result.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&urls))
+		}
+		shortURLs := make([]string, 0, len(urls))
+		for _, u := range urls {
+			shortURLs = append(shortURLs, strings.TrimPrefix(u.ShortURL, "http://localhost:8080/"))
+		}
+		return result, shortURLs
+	}
+
+	t.Run("positive test: metadata", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/user/urls?limit=1", nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		result := w.Result()
+		defer func() { require.NoError(t, result.Body.Close()) }()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var urls []models.UsersURLs
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&urls))
+		require.Len(t, urls, 1)
+		assert.Equal(t, "Practicum", urls[0].Title)
+		assert.False(t, urls[0].CreatedAt.IsZero())
+		assert.False(t, urls[0].UpdatedAt.Before(urls[0].CreatedAt))
+	})
+
+	t.Run("positive test: pages follow Link", func(t *testing.T) {
+		var pages [][]string
+		target := "http://localhost:8080/api/user/urls?limit=3"
+		for target != "" {
+			result, shortURLs := get(t, target)
+			require.Equal(t, http.StatusOK, result.StatusCode)
+			pages = append(pages, shortURLs)
+
+			target = ""
+			if link := result.Header.Get("Link"); link != "" {
+				next := regexp.MustCompile(`^<(.+)>; rel="next"$`).FindStringSubmatch(link)
+				require.Len(t, next, 2)
+				u, err := url.Parse(next[1])
+				require.NoError(t, err)
+				assert.Equal(t, "3", u.Query().Get("limit"))
+				target = "http://localhost:8080" + next[1]
+			}
+		}
+		assert.Equal(t, [][]string{{"page1", "page2", "page3"}, {"page4"}}, pages)
+	})
+
+	tests := []struct {
+		name       string
+		query      string
+		shortURLs  []string
+		statusCode int
+		next       bool
+	}{
+		{
+			name:       "positive test: all urls without limit",
+			query:      "",
+			shortURLs:  []string{"page1", "page2", "page3", "page4"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "positive test: newest first",
+			query:      "?sort=-created_at&limit=2",
+			shortURLs:  []string{"page4", "page3"},
+			statusCode: http.StatusOK,
+			next:       true,
+		},
+		{
+			name:       "positive test: domain substring",
+			query:      "?domain=YANDEX",
+			shortURLs:  []string{"page1", "page3"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "positive test: active urls",
+			query:      "?deleted=false",
+			shortURLs:  []string{"page1", "page2", "page3"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "positive test: deleted urls",
+			query:      "?deleted=true",
+			shortURLs:  []string{"page4"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "positive test: nothing matches filter",
+			query:      "?domain=example.com",
+			shortURLs:  []string{},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "negativ test: invalid limit",
+			query:      "?limit=0",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "negativ test: invalid sort",
+			query:      "?sort=short_url",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "negativ test: invalid deleted",
+			query:      "?deleted=maybe",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "negativ test: invalid cursor",
+			query:      "?cursor=%21%21",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, shortURLs := get(t, "http://localhost:8080/api/user/urls"+tt.query)
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				assert.Equal(t, tt.shortURLs, shortURLs)
+				assert.Equal(t, tt.next, result.Header.Get("Link") != "")
+			}
+		})
+	}
+}
+
+func TestPutURLTags(t *testing.T) {
+	router, _, store := newTestRouter(t)
+
+	do := func(t *testing.T, method, target, body string, cookies []*http.Cookie) *http.Response {
+		t.Helper()
+		request := httptest.NewRequest(method, target, bytes.NewReader([]byte(body)))
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w.Result()
+	}
+
+	cookies := createLinks(t, router,
+		`{"url":"https://practicum.yandex.ru","alias":"tagged","tags":["Work/Reports"]}`)
+
+	result := do(t, http.MethodPost, "http://localhost:8080/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://ya.ru","tags":["home"]},
+		  {"correlation_id":"2","original_url":"https://google.com"}]`, cookies)
+	require.NoError(t, result.Body.Close())
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+
+	require.NoError(t, store.SaveURL(context.Background(), "foreign", "https://foreign.ru", "other", models.URLMeta{}))
+
+	listTagged := func(t *testing.T, tag string) map[string][]string {
+		t.Helper()
+		result := do(t, http.MethodGet, "http://localhost:8080/api/user/urls?tag="+tag, "", cookies)
+		defer func() { require.NoError(t, result.Body.Close()) }()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var urls []models.UsersURLs
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&urls))
+		tagged := make(map[string][]string, len(urls))
+		for _, u := range urls {
+			tagged[u.OriginalURL] = u.Tags
+		}
+		return tagged
+	}
+
+	assert.Equal(t, map[string][]string{"https://practicum.yandex.ru": {"work/reports"}}, listTagged(t, "work"),
+		"positive test: folder matches nested tags")
+	assert.Equal(t, map[string][]string{"https://ya.ru": {"home"}}, listTagged(t, "HOME"))
+	assert.Empty(t, listTagged(t, "work/rep"), "positive test: no partial segment match")
+
+	tests := []struct {
+		name       string
+		shortURL   string
+		body       string
+		tags       []string
+		statusCode int
+	}{
+		{
+			name:       "positive test: replace tags",
+			shortURL:   "tagged",
+			body:       `["Home", "work", "home"]`,
+			tags:       []string{"home", "work"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "positive test: clear tags",
+			shortURL:   "tagged",
+			body:       `[]`,
+			tags:       []string{},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "negativ test: invalid tag",
+			shortURL:   "tagged",
+			body:       `["no spaces"]`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "negativ test: body is not array",
+			shortURL:   "tagged",
+			body:       `{"tags":["home"]}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "negativ test: link of other user",
+			shortURL:   "foreign",
+			body:       `["home"]`,
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "negativ test: unknown link",
+			shortURL:   "unknown",
+			body:       `["home"]`,
+			statusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := do(t, http.MethodPut, "http://localhost:8080/api/user/urls/"+tt.shortURL+"/tags", tt.body, cookies)
+			defer func() { require.NoError(t, result.Body.Close()) }()
+			require.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+
+			var tags []string
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&tags))
+			assert.Equal(t, tt.tags, tags)
+			urls, err := store.GetURLByUserID(context.Background(), models.URLQuery{UserID: "other"})
+			require.NoError(t, err)
+			assert.Empty(t, urls[0].Tags, "tags of other users are untouched")
+		})
+	}
+
+	assert.Empty(t, listTagged(t, "home")["https://practicum.yandex.ru"])
+	result = do(t, http.MethodPost, "http://localhost:8080/api/shorten",
+		`{"url":"https://bad.ru","tags":["bad tag"]}`, cookies)
+	require.NoError(t, result.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode, "negativ test: invalid tags on creation")
+}
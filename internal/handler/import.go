@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	"github.com/tiunovvv/go-yandex-shortener/internal/shortener"
+)
+
+const importRoute = "/api/import"
+
+// PostImport streams the lines of a CSV or JSON Lines body into the store and
+// streams back a JSON Lines report with a result per line. The request may
+// take long, so it has no read and write deadlines.
+func (h *Handler) PostImport(c *gin.Context) {
+	userID, status := h.getUserID(c)
+	if len(userID) == 0 {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil {
+		newErrorResponce(c, http.StatusUnsupportedMediaType, "Content-Type must be text/csv or application/x-ndjson")
+		return
+	}
+
+	var reader shortener.ImportReader
+	switch mediaType {
+	case "text/csv":
+		reader = shortener.NewCSVImportReader(c.Request.Body)
+	case "application/x-ndjson", "application/jsonl", "application/json":
+		reader = shortener.NewJSONLImportReader(c.Request.Body)
+	default:
+		newErrorResponce(c, http.StatusUnsupportedMediaType, "Content-Type must be text/csv or application/x-ndjson")
+		return
+	}
+
+	h.clearDeadlines(c)
+
+	started := false
+	encoder := json.NewEncoder(c.Writer)
+	emit := func(result models.ImportResult) error {
+		if !started {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(http.StatusOK)
+			started = true
+		}
+		if result.ShortURL != "" {
+			result.ShortURL = fmt.Sprintf("%s/%s", h.config.BaseURL, result.ShortURL)
+		}
+		if err := encoder.Encode(result); err != nil {
+			return fmt.Errorf("failed to write import report: %w", err)
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	if err := h.shortener.Import(c, userID, reader, emit); err != nil {
+		h.logger.Sugar().Errorf("failed to import: %v", err)
+		if !started {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		// The status is sent already, the failure ends the report.
+		if err := encoder.Encode(errorResponce{err.Error()}); err != nil {
+			h.logger.Sugar().Errorf("failed to write import error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	"github.com/tiunovvv/go-yandex-shortener/internal/shortener"
+)
+
+const (
+	userURLsRoute = "/api/user/urls"
+	maxPageLimit  = 1000
+)
+
+// PostAPIUserURLs returns the links of the user sorted by creation time. The
+// page is selected by limit and cursor query parameters and the next one is
+// linked in the Link header; sort=-created_at reverses the order, domain and
+// deleted filter the links, tag selects the links with the tag or a tag in its
+// folder. Without limit all the links are returned.
+func (h *Handler) PostAPIUserURLs(c *gin.Context) {
+	userID, status := h.getUserID(c)
+	if len(userID) == 0 {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	query, err := parseURLQuery(c)
+	if err != nil {
+		newErrorResponce(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.UserID = userID
+
+	cursor := c.Query("cursor")
+	usersURLs, next, err := h.shortener.GetURLByUserID(c, h.config.BaseURL, query, cursor)
+	if errors.Is(err, myErrors.ErrInvalidCursor) {
+		newErrorResponce(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Sugar().Errorf("failed to get urls of user %s: %v", userID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if len(usersURLs) == 0 && cursor == "" && query.Domain == "" && query.Deleted == nil && query.Tag == "" {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if next != "" {
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextPageURL(c.Request.URL, next)))
+	}
+	c.AbortWithStatusJSON(http.StatusOK, usersURLs)
+}
+
+func parseURLQuery(c *gin.Context) (models.URLQuery, error) {
+	var query models.URLQuery
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return query, fmt.Errorf("limit must be a number from 1 to %d", maxPageLimit)
+		}
+		query.Limit = n
+	}
+
+	switch c.DefaultQuery("sort", "created_at") {
+	case "created_at":
+	case "-created_at":
+		query.Desc = true
+	default:
+		return query, errors.New("sort must be created_at or -created_at")
+	}
+
+	if deleted := c.Query("deleted"); deleted != "" {
+		flag, err := strconv.ParseBool(deleted)
+		if err != nil {
+			return query, errors.New("deleted must be true or false")
+		}
+		query.Deleted = &flag
+	}
+
+	if tag := c.Query("tag"); tag != "" {
+		tags, err := shortener.NormalizeTags([]string{tag})
+		if err != nil {
+			return query, err
+		}
+		query.Tag = tags[0]
+	}
+
+	query.Domain = c.Query("domain")
+	return query, nil
+}
+
+// PutURLTags replaces the tags of a link of the user with a JSON array of
+// tags and responds with them normalized.
+func (h *Handler) PutURLTags(c *gin.Context) {
+	userID, status := h.getUserID(c)
+	if len(userID) == 0 {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	var tags []string
+	if err := c.ShouldBindJSON(&tags); err != nil {
+		newErrorResponce(c, http.StatusBadRequest, fmt.Sprintf("failed to decode tags: %v", err))
+		return
+	}
+
+	normalized, err := h.shortener.SetTags(c, userID, c.Param("id"), tags)
+	if errors.Is(err, myErrors.ErrInvalidTags) {
+		newErrorResponce(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, myErrors.ErrURLNotFound) {
+		newErrorResponce(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Sugar().Errorf("failed to set tags of %s: %v", c.Param("id"), err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, normalized)
+}
+
+// nextPageURL keeps the parameters of the current request and replaces its
+// cursor.
+func nextPageURL(current *url.URL, cursor string) string {
+	values := current.Query()
+	values.Set("cursor", cursor)
+	return (&url.URL{Path: current.Path, RawQuery: values.Encode()}).String()
+}
@@ -0,0 +1,41 @@
+package idgen
+
+// Hashids encodes a counter like Sequence, but with an alphabet shuffled by a
+// salt, so that consecutive codes do not look consecutive.
+type Hashids struct {
+	counter  *counter
+	alphabet string
+	length   int
+}
+
+func NewHashids(alphabet string, length int, salt string, leaser Leaser) *Hashids {
+	return &Hashids{alphabet: shuffle(alphabet, salt), length: length, counter: &counter{leaser: leaser}}
+}
+
+func (h *Hashids) Generate() (string, error) {
+	n, err := h.counter.Next()
+	if err != nil {
+		return "", err
+	}
+	// Rotate the alphabet by the lowest digit so neighbours differ everywhere.
+	lottery := int(n % uint64(len(h.alphabet)))
+	alphabet := h.alphabet[lottery:] + h.alphabet[:lottery]
+	return string(h.alphabet[lottery]) + encode(n, alphabet, h.length-1), nil
+}
+
+// shuffle is the consistent shuffle used by hashids.
+func shuffle(alphabet string, salt string) string {
+	if salt == "" {
+		return alphabet
+	}
+
+	result := []byte(alphabet)
+	for i, v, p := len(result)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		p += int(salt[v])
+		j := (int(salt[v]) + v + p) % i
+		result[i], result[j] = result[j], result[i]
+		v++
+	}
+	return string(result)
+}
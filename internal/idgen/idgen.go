@@ -0,0 +1,90 @@
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	DefaultLength   = 8
+	// MaxLength is the width of the short_url column of the database.
+	MaxLength = 64
+
+	KindRandom   = "random"
+	KindSequence = "sequence"
+	KindHashids  = "hashids"
+)
+
+var ErrInvalidAlphabet = errors.New("invalid alphabet")
+
+// IDGenerator produces candidate short codes. Implementations must be safe for
+// concurrent use.
+type IDGenerator interface {
+	Generate() (string, error)
+}
+
+// New builds the generator named by kind. Sequence based generators take their
+// counter from leaser, so that codes issued after a restart or by another
+// instance do not repeat.
+func New(kind string, alphabet string, length int, salt string, leaser Leaser) (IDGenerator, error) {
+	if alphabet == "" {
+		alphabet = DefaultAlphabet
+	}
+	if length <= 0 {
+		length = DefaultLength
+	}
+	if length > MaxLength {
+		return nil, fmt.Errorf("id length %d is over %d", length, MaxLength)
+	}
+	if err := checkAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+
+	if leaser == nil && (kind == KindSequence || kind == KindHashids) {
+		return nil, fmt.Errorf("id generator %q needs a counter leaser", kind)
+	}
+
+	switch kind {
+	case KindRandom, "":
+		return NewRandom(alphabet, length), nil
+	case KindSequence:
+		return NewSequence(alphabet, length, leaser), nil
+	case KindHashids:
+		return NewHashids(alphabet, length, salt, leaser), nil
+	default:
+		return nil, fmt.Errorf("unknown id generator %q", kind)
+	}
+}
+
+// checkAlphabet accepts only the unreserved characters of RFC 3986, the ones
+// that reach GET /:id unchanged.
+func checkAlphabet(alphabet string) error {
+	const minAlphabetLength = 2
+
+	if len(alphabet) < minAlphabetLength {
+		return fmt.Errorf("alphabet must have at least %d characters: %w", minAlphabetLength, ErrInvalidAlphabet)
+	}
+
+	seen := make(map[rune]struct{}, len(alphabet))
+	for _, r := range alphabet {
+		if !isUnreserved(r) {
+			return fmt.Errorf("alphabet character %q is not URL safe: %w", r, ErrInvalidAlphabet)
+		}
+		if _, found := seen[r]; found {
+			return fmt.Errorf("alphabet character %q is repeated: %w", r, ErrInvalidAlphabet)
+		}
+		seen[r] = struct{}{}
+	}
+	return nil
+}
+
+func isUnreserved(r rune) bool {
+	switch {
+	case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		return true
+	default:
+		return strings.ContainsRune("-._~", r)
+	}
+}
@@ -0,0 +1,156 @@
+package idgen
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memLeaser leases the counter of one process, like the storage does for all
+// the instances.
+type memLeaser struct {
+	mu   sync.Mutex
+	next uint64
+}
+
+func (l *memLeaser) LeaseIDs(ctx context.Context, count uint64) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := l.next
+	l.next += count
+	return start, nil
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		alphabet string
+		length   int
+		unique   bool
+	}{
+		{name: "random with defaults", kind: KindRandom},
+		{name: "random with custom alphabet", kind: KindRandom, alphabet: "abc", length: 12},
+		{name: "sequence", kind: KindSequence, length: 10, unique: true},
+		{name: "hashids", kind: KindHashids, length: 10, unique: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator, err := New(tt.kind, tt.alphabet, tt.length, "salt", &memLeaser{})
+			require.NoError(t, err)
+
+			alphabet := tt.alphabet
+			if alphabet == "" {
+				alphabet = DefaultAlphabet
+			}
+			length := tt.length
+			if length == 0 {
+				length = DefaultLength
+			}
+
+			const count = 1000
+			seen := make(map[string]struct{}, count)
+			for i := 0; i < count; i++ {
+				id, err := generator.Generate()
+				require.NoError(t, err)
+				assert.Len(t, id, length)
+				for _, r := range id {
+					assert.Contains(t, alphabet, string(r))
+				}
+				seen[id] = struct{}{}
+			}
+			if tt.unique {
+				assert.Len(t, seen, count)
+			}
+		})
+	}
+}
+
+func TestSharedLeaser(t *testing.T) {
+	for _, kind := range []string{KindSequence, KindHashids} {
+		t.Run(kind, func(t *testing.T) {
+			leaser := &memLeaser{}
+			const count = 3 * leaseSize
+			seen := make(map[string]struct{}, 3*count)
+			generate := func(generator IDGenerator) {
+				for i := 0; i < count; i++ {
+					id, err := generator.Generate()
+					require.NoError(t, err)
+					seen[id] = struct{}{}
+				}
+			}
+
+			first, err := New(kind, "", DefaultLength, "salt", leaser)
+			require.NoError(t, err)
+			second, err := New(kind, "", DefaultLength, "salt", leaser)
+			require.NoError(t, err)
+			generate(first)
+			generate(second)
+
+			restarted, err := New(kind, "", DefaultLength, "salt", leaser)
+			require.NoError(t, err)
+			generate(restarted)
+			assert.Len(t, seen, 3*count, "instances and restarts don't repeat codes")
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New("unknown", "", 0, "", nil)
+	assert.Error(t, err)
+
+	_, err = New(KindRandom, "aab", 0, "", nil)
+	assert.ErrorIs(t, err, ErrInvalidAlphabet)
+
+	for _, alphabet := range []string{"ab/", "ab?", "ab#", "ab%", "ab&", "ab;", "ab+"} {
+		_, err = New(KindRandom, alphabet, 0, "", nil)
+		assert.ErrorIs(t, err, ErrInvalidAlphabet, "negativ test: alphabet %q", alphabet)
+	}
+
+	_, err = New(KindRandom, "ab-._~", 0, "", nil)
+	assert.NoError(t, err, "positive test: unreserved characters")
+
+	_, err = New(KindRandom, "", MaxLength+1, "", nil)
+	assert.Error(t, err, "negativ test: too long")
+
+	_, err = New(KindSequence, "", 0, "", nil)
+	assert.Error(t, err, "negativ test: sequence without leaser")
+}
+
+func TestSequenceEncode(t *testing.T) {
+	assert.Equal(t, "0000", encode(0, "0123456789", 4))
+	assert.Equal(t, "0042", encode(42, "0123456789", 4))
+	assert.Equal(t, "123456", encode(123456, "0123456789", 4))
+}
+
+func benchmarkGenerator(b *testing.B, kind string) {
+	b.Helper()
+	generator, err := New(kind, "", DefaultLength, "salt", &memLeaser{})
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := generator.Generate(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkRandom(b *testing.B) {
+	benchmarkGenerator(b, KindRandom)
+}
+
+func BenchmarkSequence(b *testing.B) {
+	benchmarkGenerator(b, KindSequence)
+}
+
+func BenchmarkHashids(b *testing.B) {
+	benchmarkGenerator(b, KindHashids)
+}
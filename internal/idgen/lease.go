@@ -0,0 +1,48 @@
+package idgen
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// leaseSize is the count of counter values leased at once. Values left of
+	// a lease are lost on restart.
+	leaseSize    = 1000
+	leaseTimeout = 5 * time.Second
+)
+
+// Leaser hands out ranges of counter values that were never handed out
+// before, to this or any other instance. The storage implements it.
+type Leaser interface {
+	LeaseIDs(ctx context.Context, count uint64) (uint64, error)
+}
+
+// counter returns the values of the ranges leased from leaser one by one.
+type counter struct {
+	leaser Leaser
+	mu     sync.Mutex
+	next   uint64
+	end    uint64
+}
+
+func (c *counter) Next() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.next == c.end {
+		ctx, cancel := context.WithTimeout(context.Background(), leaseTimeout)
+		defer cancel()
+		start, err := c.leaser.LeaseIDs(ctx, leaseSize)
+		if err != nil {
+			return 0, fmt.Errorf("failed to lease ids: %w", err)
+		}
+		c.next, c.end = start, start+leaseSize
+	}
+
+	n := c.next
+	c.next++
+	return n, nil
+}
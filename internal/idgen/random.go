@@ -0,0 +1,30 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// Random draws every character independently from crypto/rand.
+type Random struct {
+	alphabet string
+	length   int
+}
+
+func NewRandom(alphabet string, length int) *Random {
+	return &Random{alphabet: alphabet, length: length}
+}
+
+func (r *Random) Generate() (string, error) {
+	base := big.NewInt(int64(len(r.alphabet)))
+	str := make([]byte, r.length)
+	for i := range str {
+		n, err := rand.Int(rand.Reader, base)
+		if err != nil {
+			return "", fmt.Errorf("failed to read random number: %w", err)
+		}
+		str[i] = r.alphabet[n.Int64()]
+	}
+	return string(str), nil
+}
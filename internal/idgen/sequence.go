@@ -0,0 +1,44 @@
+package idgen
+
+import (
+	"strings"
+)
+
+// Sequence encodes a monotonically increasing counter in the alphabet base,
+// left-padded with the first alphabet character up to length.
+type Sequence struct {
+	counter  *counter
+	alphabet string
+	length   int
+}
+
+func NewSequence(alphabet string, length int, leaser Leaser) *Sequence {
+	return &Sequence{alphabet: alphabet, length: length, counter: &counter{leaser: leaser}}
+}
+
+func (s *Sequence) Generate() (string, error) {
+	n, err := s.counter.Next()
+	if err != nil {
+		return "", err
+	}
+	return encode(n, s.alphabet, s.length), nil
+}
+
+func encode(n uint64, alphabet string, length int) string {
+	base := uint64(len(alphabet))
+	buf := make([]byte, 0, length)
+	for n > 0 {
+		buf = append(buf, alphabet[n%base])
+		n /= base
+	}
+	for len(buf) < length {
+		buf = append(buf, alphabet[0])
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(buf))
+	for i := len(buf) - 1; i >= 0; i-- {
+		sb.WriteByte(buf[i])
+	}
+	return sb.String()
+}
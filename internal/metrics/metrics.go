@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+)
+
+const namespace = "shortener"
+
+// Metrics holds the Prometheus collectors of the service. Methods of a nil
+// Metrics do nothing, so instrumentation is optional.
+type Metrics struct {
+	registry      *prometheus.Registry
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	redirects     *prometheus.CounterVec
+	storeDuration *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Count of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redirects_total",
+			Help:      "Count of short URL hits by result.",
+		}, []string{"result"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Latency of store operations by method and result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.redirects,
+		m.storeDuration,
+	)
+	return m
+}
+
+// Handler serves the collected metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records a handled HTTP request. Requests not matching any
+// route share the empty route label to bound the label cardinality.
+func (m *Metrics) ObserveRequest(route, method string, code int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	m.duration.WithLabelValues(route, method).Observe(duration.Seconds())
+}
+
+// Redirect records a hit of a short URL. The result is one of "found",
+// "deleted", "expired" or "error".
+func (m *Metrics) Redirect(result string) {
+	if m == nil {
+		return
+	}
+	m.redirects.WithLabelValues(result).Inc()
+}
+
+// ObserveStore records the latency of a store method.
+func (m *Metrics) ObserveStore(method string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.storeDuration.WithLabelValues(method, result).Observe(duration.Seconds())
+}
+
+// RegisterDeleteQueue exports the state of the async deletion queue.
+func (m *Metrics) RegisterDeleteQueue(stats func() models.DeleteQueueStats) {
+	if m == nil {
+		return
+	}
+	gauge := func(name, help string, value func(models.DeleteQueueStats) int64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "delete_queue",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+	counter := func(name, help string, value func(models.DeleteQueueStats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "delete_queue",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+
+	m.registry.MustRegister(
+		gauge("depth", "Count of short URLs waiting for deletion.",
+			func(s models.DeleteQueueStats) int64 { return s.Depth }),
+		counter("deleted_total", "Count of short URLs marked as deleted.",
+			func(s models.DeleteQueueStats) int64 { return s.Deleted }),
+		counter("failed_total", "Count of short URLs failed to be marked as deleted.",
+			func(s models.DeleteQueueStats) int64 { return s.Failed }),
+		counter("batches_total", "Count of flushed deletion batches.",
+			func(s models.DeleteQueueStats) int64 { return s.Batches }),
+	)
+}
+
+// RegisterPool exports the stats of a pgx connection pool.
+func (m *Metrics) RegisterPool(pool *pgxpool.Pool) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(&poolCollector{pool: pool})
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tiunovvv/go-yandex-shortener/internal/auth"
+	"go.uber.org/zap"
+)
+
+func BearerAuth(tokens *auth.TokenManager, log *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		const prefix = "Bearer "
+
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, prefix) {
+			c.Next()
+			return
+		}
+
+		userID, err := tokens.Parse(strings.TrimSpace(strings.TrimPrefix(header, prefix)))
+		if err != nil {
+			log.Sugar().Infof("failed to authenticate bearer token: %v", err)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(userIDKey, userID)
+		c.Next()
+	}
+}
@@ -12,13 +12,28 @@ import (
 )
 
 type compressWriter struct {
-	io.Writer
 	gin.ResponseWriter
+	Writer *gzip.Writer
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
 	w, err := c.Writer.Write(p)
-	return w, fmt.Errorf("failed to write by compressor: %w", err)
+	if err != nil {
+		return w, fmt.Errorf("failed to write by compressor: %w", err)
+	}
+	return w, nil
+}
+
+// Flush sends the data compressed so far, so streamed responses are not held
+// in the gzip buffer.
+func (c *compressWriter) Flush() {
+	if err := c.Writer.Flush(); err == nil {
+		c.ResponseWriter.Flush()
+	}
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
 }
 
 func GinGzip(log *zap.Logger) gin.HandlerFunc {
@@ -5,13 +5,19 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"github.com/gofrs/uuid"
+	"github.com/tiunovvv/go-yandex-shortener/internal/auth"
 	"go.uber.org/zap"
 )
 
+const userIDKey = "user_id"
+
 func SetCookie(log *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		const userIDKey = "user_id"
+		if _, exists := c.Get(userIDKey); exists {
+			c.Next()
+			return
+		}
+
 		session := sessions.Default(c)
 
 		if userID, ok := session.Get(userIDKey).(string); ok {
@@ -20,7 +26,7 @@ func SetCookie(log *zap.Logger) gin.HandlerFunc {
 			return
 		}
 
-		userID, err := generateUniqueUserID()
+		userID, err := auth.NewUserID()
 		if err != nil {
 			log.Sugar().Errorf("failed to generate userID: %w", err)
 			c.AbortWithStatus(http.StatusBadRequest)
@@ -36,8 +42,3 @@ func SetCookie(log *zap.Logger) gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-func generateUniqueUserID() (string, error) {
-	uuid, err := uuid.NewV4()
-	return uuid.String(), err
-}
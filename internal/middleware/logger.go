@@ -2,10 +2,12 @@ package middleware
 
 import (
 	"fmt"
+	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tiunovvv/go-yandex-shortener/internal/metrics"
 	"go.uber.org/zap"
 )
 
@@ -17,10 +19,18 @@ type bodyLogWriter struct {
 func (w *bodyLogWriter) Write(b []byte) (int, error) {
 	size, err := w.ResponseWriter.Write(b)
 	w.size += size
-	return size, fmt.Errorf("failed to calculate size: %w", err)
+	if err != nil {
+		return size, fmt.Errorf("failed to calculate size: %w", err)
+	}
+	return size, nil
+}
+
+func (w *bodyLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
-func GinLogger(log *zap.Logger) gin.HandlerFunc {
+// GinLogger logs every request and records it in m when m is not nil.
+func GinLogger(log *zap.Logger, m *metrics.Metrics) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 
@@ -28,6 +38,7 @@ func GinLogger(log *zap.Logger) gin.HandlerFunc {
 		c.Writer = blw
 		c.Next()
 		duration := time.Since(start)
+		m.ObserveRequest(c.FullPath(), c.Request.Method, c.Writer.Status(), duration)
 
 		log.Info("Request:",
 			zap.String("URI", c.Request.RequestURI),
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// TrustedSubnet lets through only requests whose X-Real-IP belongs to cidr.
+// An empty cidr forbids everything.
+func TrustedSubnet(cidr string, log *zap.Logger) gin.HandlerFunc {
+	var subnet *net.IPNet
+	if cidr != "" {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Sugar().Errorf("failed to parse trusted subnet %s: %v", cidr, err)
+		}
+		subnet = ipNet
+	}
+
+	return func(c *gin.Context) {
+		if subnet == nil {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		ip := net.ParseIP(c.GetHeader("X-Real-IP"))
+		if ip == nil || !subnet.Contains(ip) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
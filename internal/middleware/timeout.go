@@ -6,12 +6,29 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 )
 
-func GinTimeOut(dt time.Duration, msg string) gin.HandlerFunc {
+var tracer = otel.Tracer("github.com/tiunovvv/go-yandex-shortener/internal/middleware")
+
+// GinTimeOut answers msg when the rest of the chain doesn't finish in dt. The
+// deadline and the span of the chain are passed down in the request context.
+// Routes listed in skip are long-running and have no deadline.
+func GinTimeOut(dt time.Duration, msg string, skip ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		for _, route := range skip {
+			if c.FullPath() == route {
+				c.Next()
+				return
+			}
+		}
+
 		ctx, cancel := context.WithTimeout(c.Request.Context(), dt)
 		defer cancel()
+		ctx, span := tracer.Start(ctx, "middleware.timeout")
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
 		done := make(chan struct{})
 
 		go func() {
@@ -22,6 +39,7 @@ func GinTimeOut(dt time.Duration, msg string) gin.HandlerFunc {
 		select {
 		case <-done:
 		case <-ctx.Done():
+			span.SetStatus(codes.Error, "timeout")
 			c.String(http.StatusGatewayTimeout, msg)
 			c.Abort()
 		}
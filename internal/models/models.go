@@ -1,7 +1,22 @@
 package models
 
+import "time"
+
 type ReqAPI struct {
-	URL string `json:"url"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	URL        string     `json:"url"`
+	Alias      string     `json:"alias,omitempty"`
+	Title      string     `json:"title,omitempty"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+}
+
+// URLMeta is saved together with a new url. Zero ExpiresAt means the url never
+// expires.
+type URLMeta struct {
+	ExpiresAt time.Time
+	Tags      []string
+	Title     string
 }
 
 type ResAPI struct {
@@ -9,8 +24,17 @@ type ResAPI struct {
 }
 
 type ReqAPIBatch struct {
-	ID      string `json:"correlation_id"`
-	FullURL string `json:"original_url"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	ID         string     `json:"correlation_id"`
+	FullURL    string     `json:"original_url"`
+	Title      string     `json:"title,omitempty"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+}
+
+type ResPing struct {
+	Status  string `json:"status"`
+	Storage string `json:"storage"`
 }
 
 type ResAPIBatch struct {
@@ -19,6 +43,101 @@ type ResAPIBatch struct {
 }
 
 type UsersURLs struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Tags        []string  `json:"tags,omitempty"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	Title       string    `json:"title,omitempty"`
+	Deleted     bool      `json:"deleted"`
+}
+
+// URLQuery selects the urls of a user. Urls are sorted by creation time and
+// then by short URL, After continues from the last url of a previous page.
+// Zero Limit means no limit, nil Deleted means both deleted and active urls.
+// Tag matches the urls having the tag or a tag in its folder: "work" matches
+// "work" and "work/reports".
+type URLQuery struct {
+	After    *URLCursor
+	Deleted  *bool
+	UserID   string
+	ShortURL string
+	Domain   string
+	Tag      string
+	Limit    int
+	Desc     bool
+}
+
+// URLCursor is a position in the urls sorted by URLQuery.
+type URLCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ShortURL  string    `json:"s"`
+}
+
+type Click struct {
+	Time      time.Time `json:"time"`
+	ShortURL  string    `json:"short_url"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+}
+
+type DayClicks struct {
+	Day    string `json:"day"`
+	Clicks int64  `json:"clicks"`
+}
+
+type URLStats struct {
+	ShortURL string      `json:"short_url"`
+	Days     []DayClicks `json:"days"`
+	Total    int64       `json:"total"`
+}
+
+type ResAuthToken struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Token     string    `json:"token"`
+}
+
+type Stats struct {
+	DeleteQueue DeleteQueueStats `json:"delete_queue"`
+	URLs        int              `json:"urls"`
+	Users       int              `json:"users"`
+}
+
+// DeleteQueueStats reports the short URLs waiting for deletion and the totals
+// of the processed ones.
+type DeleteQueueStats struct {
+	Depth   int64 `json:"depth"`
+	Deleted int64 `json:"deleted"`
+	Failed  int64 `json:"failed"`
+	Batches int64 `json:"batches"`
+}
+
+// ImportRecord is a line of an imported CSV or JSON Lines file. Err is set
+// when the line can't be parsed.
+type ImportRecord struct {
+	Err     error  `json:"-"`
+	FullURL string `json:"original_url"`
+	Alias   string `json:"alias,omitempty"`
+	Line    int    `json:"-"`
+}
+
+// ImportResult reports the outcome of an imported line. Status is one of
+// created, conflict or invalid.
+type ImportResult struct {
+	Status      string `json:"status"`
+	ShortURL    string `json:"short_url,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Line        int    `json:"line"`
+}
+
+// ExportedURL is a link of a user in an export. CreatedAt and Clicks are nil
+// when the store doesn't track them.
+type ExportedURL struct {
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	Clicks      *int64     `json:"clicks,omitempty"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	Deleted     bool       `json:"deleted"`
 }
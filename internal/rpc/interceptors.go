@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/tiunovvv/go-yandex-shortener/internal/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const authorizationKey = "authorization"
+
+type userIDKey struct{}
+
+// UnaryLogger mirrors middleware.GinLogger for gRPC calls.
+func UnaryLogger(log *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		duration := time.Since(start)
+
+		log.Info("Request:",
+			zap.String("Method", info.FullMethod),
+			zap.String("StatusCode", status.Code(err).String()),
+			zap.String("Duration", duration.String()),
+		)
+		return resp, err
+	}
+}
+
+// UnaryAuth mirrors middleware.BearerAuth and middleware.SetCookie: a valid
+// bearer token identifies the user, a missing one gets a new user whose token
+// is sent back in the response header.
+func UnaryAuth(tokens *auth.TokenManager, log *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		const prefix = "Bearer "
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get(authorizationKey); len(values) != 0 && strings.HasPrefix(values[0], prefix) {
+			userID, err := tokens.Parse(strings.TrimSpace(strings.TrimPrefix(values[0], prefix)))
+			if err != nil {
+				log.Sugar().Infof("failed to authenticate bearer token: %v", err)
+				return nil, status.Error(codes.Unauthenticated, "invalid token")
+			}
+			return handler(context.WithValue(ctx, userIDKey{}, userID), req)
+		}
+
+		userID, err := auth.NewUserID()
+		if err != nil {
+			log.Sugar().Errorf("failed to generate userID: %v", err)
+			return nil, status.Error(codes.Internal, "failed to generate userID")
+		}
+
+		token, _, err := tokens.Issue(userID)
+		if err != nil {
+			log.Sugar().Errorf("failed to issue token: %v", err)
+			return nil, status.Error(codes.Internal, "failed to issue token")
+		}
+
+		if err := grpc.SetHeader(ctx, metadata.Pairs(authorizationKey, prefix+token)); err != nil {
+			log.Sugar().Errorf("failed to set authorization header: %v", err)
+			return nil, status.Error(codes.Internal, "failed to set authorization header")
+		}
+
+		return handler(context.WithValue(ctx, userIDKey{}, userID), req)
+	}
+}
+
+func getUserID(ctx context.Context) (string, error) {
+	userID, ok := ctx.Value(userIDKey{}).(string)
+	if !ok || userID == "" {
+		return "", status.Error(codes.Unauthenticated, "user is not identified")
+	}
+	return userID, nil
+}
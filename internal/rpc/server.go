@@ -0,0 +1,207 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/tiunovvv/go-yandex-shortener/internal/auth"
+	"github.com/tiunovvv/go-yandex-shortener/internal/config"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	pb "github.com/tiunovvv/go-yandex-shortener/internal/proto"
+	"github.com/tiunovvv/go-yandex-shortener/internal/shortener"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Server struct {
+	pb.UnimplementedShortenerServer
+	config    *config.Config
+	shortener *shortener.Shortener
+	logger    *zap.Logger
+}
+
+func NewServer(config *config.Config, shortener *shortener.Shortener, logger *zap.Logger) *Server {
+	return &Server{
+		config:    config,
+		shortener: shortener,
+		logger:    logger,
+	}
+}
+
+// NewGRPCServer builds a grpc.Server with the Shortener service and the
+// logger and auth interceptors.
+func NewGRPCServer(config *config.Config, shortener *shortener.Shortener, logger *zap.Logger) *grpc.Server {
+	tokens := auth.NewTokenManagerFromConfig(config)
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		UnaryLogger(logger),
+		UnaryAuth(tokens, logger),
+	))
+	pb.RegisterShortenerServer(grpcServer, NewServer(config, shortener, logger))
+	return grpcServer
+}
+
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if _, err := url.ParseRequestURI(req.GetUrl()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not URL", req.GetUrl())
+	}
+
+	expiresAt, err := shortener.ExpirationTime(timestampToTime(req.GetExpiresAt()), req.GetTtlSeconds())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := models.URLMeta{ExpiresAt: expiresAt}
+	var shortURL string
+	if req.GetAlias() != "" {
+		shortURL, err = s.shortener.GetShortURLWithAlias(ctx, req.GetUrl(), req.GetAlias(), userID, meta)
+	} else {
+		shortURL, err = s.shortener.GetShortURL(ctx, req.GetUrl(), userID, meta)
+	}
+
+	switch {
+	case errors.Is(err, myErrors.ErrInvalidAlias):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, myErrors.ErrAliasAlreadyExists):
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, myErrors.ErrURLAlreadySaved):
+		return &pb.ShortenResponse{Result: s.fullShortURL(shortURL), AlreadySaved: true}, nil
+	case err != nil:
+		s.logger.Sugar().Errorf("failed to save %s: %v", req.GetUrl(), err)
+		return nil, status.Error(codes.Internal, "failed to save URL")
+	}
+
+	return &pb.ShortenResponse{Result: s.fullShortURL(shortURL)}, nil
+}
+
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reqSlice := make([]models.ReqAPIBatch, 0, len(req.GetItems()))
+	for _, item := range req.GetItems() {
+		reqSlice = append(reqSlice, models.ReqAPIBatch{
+			ExpiresAt:  timestampToTime(item.GetExpiresAt()),
+			ID:         item.GetCorrelationId(),
+			FullURL:    item.GetOriginalUrl(),
+			TTLSeconds: item.GetTtlSeconds(),
+		})
+	}
+
+	resSlice, err := s.shortener.GetShortURLBatch(ctx, reqSlice, userID)
+	if errors.Is(err, myErrors.ErrInvalidExpiration) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		s.logger.Sugar().Errorf("failed to save list of URLs: %v", err)
+		return nil, status.Error(codes.Internal, "failed to save list of URLs")
+	}
+
+	resp := &pb.ShortenBatchResponse{Items: make([]*pb.BatchResult, 0, len(resSlice))}
+	for _, res := range resSlice {
+		resp.Items = append(resp.Items, &pb.BatchResult{CorrelationId: res.ID, ShortUrl: s.fullShortURL(res.ShortURL)})
+	}
+	return resp, nil
+}
+
+func (s *Server) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	shortURL := req.GetShortUrl()
+	if shortURL == "" {
+		return nil, status.Error(codes.InvalidArgument, "short_url is empty")
+	}
+
+	fullURL, deletedFlag, err := s.shortener.GetFullURL(ctx, shortURL)
+	if errors.Is(err, myErrors.ErrURLExpired) || (err == nil && deletedFlag) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is gone", shortURL)
+	}
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	s.shortener.RecordClick(shortURL, "", "", peerIP(ctx))
+
+	return &pb.ExpandResponse{OriginalUrl: fullURL}, nil
+}
+
+// peerIP returns the IP of the client without the port, the same way the
+// HTTP redirect records it.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func (s *Server) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usersURLs, _, err := s.shortener.GetURLByUserID(ctx, s.config.BaseURL, models.URLQuery{UserID: userID}, "")
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(usersURLs))}
+	for _, u := range usersURLs {
+		resp.Urls = append(resp.Urls, &pb.UserURL{ShortUrl: u.ShortURL, OriginalUrl: u.OriginalURL})
+	}
+	return resp, nil
+}
+
+func (s *Server) DeleteUserURLs(
+	ctx context.Context,
+	req *pb.DeleteUserURLsRequest,
+) (*pb.DeleteUserURLsResponse, error) {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.shortener.SetDeletedFlag(ctx, userID, req.GetShortUrls()); err != nil {
+		if errors.Is(err, myErrors.ErrShuttingDown) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+func (s *Server) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.shortener.CheckConnect(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &pb.PingResponse{}, nil
+}
+
+func (s *Server) fullShortURL(shortURL string) string {
+	return fmt.Sprintf("%s/%s", s.config.BaseURL, shortURL)
+}
+
+func timestampToTime(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
@@ -0,0 +1,134 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tiunovvv/go-yandex-shortener/internal/config"
+	"github.com/tiunovvv/go-yandex-shortener/internal/idgen"
+	pb "github.com/tiunovvv/go-yandex-shortener/internal/proto"
+	"github.com/tiunovvv/go-yandex-shortener/internal/shortener"
+	"github.com/tiunovvv/go-yandex-shortener/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T) pb.ShortenerClient {
+	t.Helper()
+
+	config := &config.Config{BaseURL: "http://localhost:8080", JWTSecret: "secret"}
+	logger := zap.NewNop()
+	sh := shortener.NewShortener(storage.NewMemory(), idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), logger)
+
+	const bufSize = 1 << 20
+	listener := bufconn.Listen(bufSize)
+	grpcServer := NewGRPCServer(config, sh, logger)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			t.Log(err)
+		}
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, conn.Close()) })
+
+	return pb.NewShortenerClient(conn)
+}
+
+func TestShortenerService(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	var header metadata.MD
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru", Alias: "grpc"},
+		grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/grpc", resp.GetResult())
+	require.NotEmpty(t, header.Get(authorizationKey))
+
+	authCtx := metadata.AppendToOutgoingContext(ctx, authorizationKey, header.Get(authorizationKey)[0])
+
+	resp, err = client.Shorten(authCtx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru"})
+	require.NoError(t, err)
+	assert.True(t, resp.GetAlreadySaved())
+
+	_, err = client.Shorten(authCtx, &pb.ShortenRequest{Url: "https://yandex.ru", Alias: "grpc"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.Shorten(authCtx, &pb.ShortenRequest{Url: "yandex"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	batch, err := client.ShortenBatch(authCtx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "https://google.ru"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, batch.GetItems(), 1)
+
+	expand, err := client.Expand(ctx, &pb.ExpandRequest{ShortUrl: "grpc"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru", expand.GetOriginalUrl())
+
+	_, err = client.Expand(ctx, &pb.ExpandRequest{ShortUrl: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err := client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, list.GetUrls(), 2)
+
+	list, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, list.GetUrls())
+
+	badCtx := metadata.AppendToOutgoingContext(ctx, authorizationKey, "Bearer invalid")
+	_, err = client.ListUserURLs(badCtx, &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Ping(ctx, &pb.PingRequest{})
+	assert.NoError(t, err)
+}
+
+func TestPeerIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{
+			name: "positive test: port is stripped",
+			ctx:  peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 51234}}),
+			want: "10.0.0.1",
+		},
+		{
+			name: "positive test: IPv6",
+			ctx:  peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv6loopback, Port: 51234}}),
+			want: "::1",
+		},
+		{
+			name: "negativ test: no peer",
+			ctx:  context.Background(),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, peerIP(tt.ctx))
+		})
+	}
+}
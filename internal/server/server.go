@@ -4,54 +4,155 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/tiunovvv/go-yandex-shortener/internal/config"
 	"github.com/tiunovvv/go-yandex-shortener/internal/handler"
+	"github.com/tiunovvv/go-yandex-shortener/internal/idgen"
+	"github.com/tiunovvv/go-yandex-shortener/internal/metrics"
+	"github.com/tiunovvv/go-yandex-shortener/internal/rpc"
 	"github.com/tiunovvv/go-yandex-shortener/internal/shortener"
 	"github.com/tiunovvv/go-yandex-shortener/internal/storage"
+	"github.com/tiunovvv/go-yandex-shortener/internal/tracing"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
-	logger *zap.Logger
-	store  storage.Store
+	logger         *zap.Logger
+	store          storage.Store
+	grpcServer     *grpc.Server
+	redirectServer *http.Server
+	shortener      *shortener.Shortener
+	stopBackground context.CancelFunc
+	stopTracing    func(context.Context) error
+	background     *sync.WaitGroup
+	grpcAddress    string
+	shutdown       time.Duration
+	tlsCertFile    string
+	tlsKeyFile     string
 	*http.Server
 }
 
-func NewServer(ctx context.Context) (*Server, error) {
+func NewServer(ctx context.Context) (_ *Server, err error) {
 	logger, err := zap.NewDevelopment()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build logger: %w", err)
 	}
 
-	config := config.NewConfig(logger)
+	config, err := config.NewConfig(logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build config: %w", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, config.TraceExporter, config.OTLPEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
 	store, err := storage.NewStore(ctx, config, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create store: %w", err)
 	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, store.Close())
+		}
+	}()
 
-	shortener := shortener.NewShortener(store, logger)
+	backend := storage.BackendOf(store)
+	logger.Sugar().Infof("storage backend: %s, requested: %s", backend, config.StorageBackend)
+
+	metrics := metrics.New()
+	if db, ok := store.(*storage.DB); ok {
+		metrics.RegisterPool(db.Pool())
+	}
+	store = storage.NewInstrumented(store, metrics)
+
+	if config.CacheSize > 0 || config.RedisURL != "" {
+		cache, err := storage.NewCache(store, config.CacheSize, config.CacheTTL, config.RedisURL, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache: %w", err)
+		}
+		store = cache
+	}
+
+	generator, err := idgen.New(config.IDGenerator, config.IDAlphabet, config.IDLength, config.IDSalt, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create short URL generator: %w", err)
+	}
+
+	shortener := shortener.NewShortener(store, generator, logger)
+	shortener.SetBackend(backend)
+	shortener.SetClickIPKey([]byte(config.ClickIPSecret))
+	shortener.SetDeleteWorkers(config.DeleteWorkers)
+	shortener.SetDeleteBatch(config.DeleteBatchSize, config.DeleteInterval)
+	metrics.RegisterDeleteQueue(shortener.DeleteQueueStats)
 	handler := handler.NewHandler(config, shortener, logger)
+	handler.SetMetrics(metrics)
 
 	errorLog := zap.NewStdLog(logger)
-	const (
-		bytes   = 20
-		seconds = 5 * time.Second
-	)
+	const bytes = 20
+
+	ctx, stopBackground := context.WithCancel(ctx)
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		shortener.RunExpirySweeper(ctx, config.SweepInterval)
+	}()
+	go func() {
+		defer background.Done()
+		shortener.RunClickRecorder(ctx, config.ClickFlushInterval)
+	}()
+	defer func() {
+		if err != nil {
+			stopBackground()
+			background.Wait()
+		}
+	}()
+
 	s := http.Server{
 		Addr:           config.ServerAddress,
 		Handler:        handler.InitRoutes(),
 		ErrorLog:       errorLog,
 		MaxHeaderBytes: 1 << bytes,
-		ReadTimeout:    seconds,
-		WriteTimeout:   seconds,
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
+	}
+
+	var grpcServer *grpc.Server
+	if config.GRPCAddress != "" {
+		grpcServer = rpc.NewGRPCServer(config, shortener, logger)
 	}
 
-	return &Server{logger, store, &s}, nil
+	server := &Server{
+		logger:         logger,
+		store:          store,
+		grpcServer:     grpcServer,
+		shortener:      shortener,
+		background:     &background,
+		stopBackground: stopBackground,
+		stopTracing:    shutdownTracing,
+		grpcAddress:    config.GRPCAddress,
+		shutdown:       config.ShutdownTimeout,
+		Server:         &s,
+	}
+
+	if config.EnableHTTPS {
+		if err := server.setupTLS(config); err != nil {
+			return nil, err
+		}
+	}
+
+	return server, nil
 }
 
 func (s *Server) Start() error {
@@ -68,30 +169,130 @@ func (s *Server) Start() error {
 		}
 	}()
 
+	// The gRPC listener is opened before anything is started, so a busy
+	// address fails Start with nothing left running.
+	var listener net.Listener
+	if s.grpcServer != nil {
+		listener, err = net.Listen("tcp", s.grpcAddress)
+		if err != nil {
+			s.stopBackground()
+			s.background.Wait()
+			return fmt.Errorf("failed to listen on %s: %w", s.grpcAddress, err)
+		}
+	}
+
+	s.shortener.Start()
+
 	go func() {
-		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if s.tlsCertFile != "" {
+			err = s.ListenAndServeTLS(s.tlsCertFile, s.tlsKeyFile)
+		} else {
+			err = s.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.logger.Error("could not listen on", zap.String("addr", s.Addr), zap.Error(err))
 		}
 	}()
 
+	if s.redirectServer != nil {
+		go func() {
+			if err := s.redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("could not listen on", zap.String("addr", s.redirectServer.Addr), zap.Error(err))
+			}
+		}()
+		s.logger.Info("HTTP to HTTPS redirect is ready", zap.String("addr", s.redirectServer.Addr))
+	}
+
+	if s.grpcServer != nil {
+		go func() {
+			if err := s.grpcServer.Serve(listener); err != nil {
+				s.logger.Error("could not serve gRPC", zap.String("addr", s.grpcAddress), zap.Error(err))
+			}
+		}()
+		s.logger.Info("gRPC server is ready to handle requests", zap.String("addr", s.grpcAddress))
+	}
+
 	s.logger.Info("server is ready to handle requests", zap.String("addr", s.Addr))
 	s.gracefulShutdown()
 	return err
 }
 
+// gracefulShutdown waits for SIGINT, SIGTERM or SIGQUIT, then stops accepting
+// requests and drains the queued deletions and clicks. The store is closed by
+// Start after that, all within the shutdown timeout.
 func (s *Server) gracefulShutdown() {
 	quit := make(chan os.Signal, 1)
 
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(quit)
 	sig := <-quit
 	s.logger.Info("server is shutting down", zap.String("reason", sig.String()))
-	const seconds = 10 * time.Second
-	ctx, cancelCtx := context.WithTimeout(context.Background(), seconds)
+	ctx, cancelCtx := context.WithTimeout(context.Background(), s.shutdown)
 	defer cancelCtx()
 
 	s.SetKeepAlivesEnabled(false)
 	if err := s.Shutdown(ctx); err != nil {
 		s.logger.Error("failed to gracefully shutdown the server", zap.Error(err))
 	}
+	if s.redirectServer != nil {
+		if err := s.redirectServer.Shutdown(ctx); err != nil {
+			s.logger.Error("failed to gracefully shutdown the redirect server", zap.Error(err))
+		}
+	}
+	if s.grpcServer != nil {
+		s.grpcServer.GracefulStop()
+	}
+
+	if err := s.shortener.Stop(ctx); err != nil {
+		s.logger.Error("failed to finish deletion", zap.Error(err))
+	}
+
+	s.stopBackground()
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Error("background tasks are not finished", zap.Error(ctx.Err()))
+	}
+	if err := s.stopTracing(ctx); err != nil {
+		s.logger.Error("failed to flush spans", zap.Error(err))
+	}
 	s.logger.Info("server is stopped")
 }
+
+func (s *Server) setupTLS(config *config.Config) error {
+	s.tlsCertFile, s.tlsKeyFile = config.TLSCertFile, config.TLSKeyFile
+
+	if s.tlsCertFile == "" || s.tlsKeyFile == "" {
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if host, _, err := net.SplitHostPort(s.Addr); err == nil {
+			hosts = append(hosts, host)
+		}
+		if baseURL, err := url.Parse(config.BaseURL); err == nil {
+			hosts = append(hosts, baseURL.Hostname())
+		}
+
+		certFile, keyFile, err := selfSignedCert(config.TLSCacheDir, hosts)
+		if err != nil {
+			return fmt.Errorf("failed to get self-signed certificate: %w", err)
+		}
+		s.tlsCertFile, s.tlsKeyFile = certFile, keyFile
+		s.logger.Info("using self-signed certificate", zap.String("cert", certFile))
+	}
+
+	if config.HTTPRedirect != "" {
+		s.redirectServer = &http.Server{
+			Addr:              config.HTTPRedirect,
+			Handler:           redirectToHTTPS(s.Addr),
+			ErrorLog:          s.ErrorLog,
+			ReadHeaderTimeout: config.ReadTimeout,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tiunovvv/go-yandex-shortener/internal/idgen"
+	"github.com/tiunovvv/go-yandex-shortener/internal/shortener"
+	"github.com/tiunovvv/go-yandex-shortener/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return address
+}
+
+func TestStartBusyGRPCAddress(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, busy.Close()) }()
+
+	store := storage.NewMemory()
+	stopped := false
+	s := &Server{
+		logger:         zap.NewNop(),
+		store:          store,
+		grpcServer:     grpc.NewServer(),
+		shortener:      shortener.NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), zap.NewNop()),
+		stopBackground: func() { stopped = true },
+		stopTracing:    func(context.Context) error { return nil },
+		background:     &sync.WaitGroup{},
+		grpcAddress:    busy.Addr().String(),
+		Server:         &http.Server{Addr: freeAddress(t)},
+	}
+
+	err = s.Start()
+	require.Error(t, err, "negativ test: gRPC address is taken")
+	assert.True(t, stopped, "background tasks are stopped")
+
+	_, err = net.Dial("tcp", s.Addr)
+	assert.Error(t, err, "HTTP server is not started")
+}
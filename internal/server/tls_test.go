@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile, err := selfSignedCert(dir, []string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	assert.NoError(t, cert.VerifyHostname("localhost"))
+	assert.NoError(t, cert.VerifyHostname("127.0.0.1"))
+
+	before, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+
+	_, _, err = selfSignedCert(dir, []string{"localhost"})
+	require.NoError(t, err)
+	after, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "cached certificate must be reused")
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name         string
+		httpsAddress string
+		request      string
+		location     string
+	}{
+		{
+			name:         "custom port",
+			httpsAddress: "localhost:8443",
+			request:      "http://example.com:8080/abc?x=1",
+			location:     "https://example.com:8443/abc?x=1",
+		},
+		{
+			name:         "default port",
+			httpsAddress: ":443",
+			request:      "http://example.com/abc",
+			location:     "https://example.com/abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			redirectToHTTPS(tt.httpsAddress).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.request, nil))
+			result := w.Result()
+			require.NoError(t, result.Body.Close())
+
+			assert.Equal(t, http.StatusPermanentRedirect, result.StatusCode)
+			assert.Equal(t, tt.location, result.Header.Get("Location"))
+		})
+	}
+}
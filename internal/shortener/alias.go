@@ -0,0 +1,71 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+)
+
+const (
+	maxAliasLength = 64
+	aliasCharset   = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_"
+)
+
+var reservedAliases = map[string]struct{}{
+	"api":     {},
+	"metrics": {},
+	"ping":    {},
+}
+
+func (sh *Shortener) GetShortURLWithAlias(
+	ctx context.Context,
+	fullURL string,
+	alias string,
+	userID string,
+	meta models.URLMeta,
+) (string, error) {
+	ctx, span := tracer.Start(ctx, "shortener.GetShortURLWithAlias")
+	defer span.End()
+
+	if err := checkAlias(alias); err != nil {
+		return "", err
+	}
+
+	err := sh.store.SaveURL(ctx, alias, fullURL, userID, meta)
+	if errors.Is(err, myErrors.ErrURLAlreadySaved) {
+		shortURL := sh.store.GetShortURL(ctx, fullURL)
+		return shortURL, myErrors.ErrURLAlreadySaved
+	}
+
+	if errors.Is(err, myErrors.ErrKeyAlreadyExists) {
+		return "", fmt.Errorf("failed to save alias %s: %w", alias, myErrors.ErrAliasAlreadyExists)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to save alias %s: %w", alias, err)
+	}
+
+	return alias, nil
+}
+
+func checkAlias(alias string) error {
+	if len(alias) == 0 || len(alias) > maxAliasLength {
+		return fmt.Errorf("alias must have from 1 to %d characters: %w", maxAliasLength, myErrors.ErrInvalidAlias)
+	}
+
+	for _, r := range alias {
+		if !strings.ContainsRune(aliasCharset, r) {
+			return fmt.Errorf("alias %s contains forbidden character %q: %w", alias, r, myErrors.ErrInvalidAlias)
+		}
+	}
+
+	if _, found := reservedAliases[strings.ToLower(alias)]; found {
+		return fmt.Errorf("alias %s is reserved: %w", alias, myErrors.ErrInvalidAlias)
+	}
+
+	return nil
+}
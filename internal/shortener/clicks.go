@@ -0,0 +1,111 @@
+package shortener
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+)
+
+const (
+	clickQueueSize = 1024
+	clickBatchSize = 100
+	clickFlushWait = 5 * time.Second
+	ipKeyLength    = 32
+)
+
+// RecordClick queues a redirect for asynchronous saving. It never blocks the
+// redirect: when the queue is full the click is dropped.
+func (sh *Shortener) RecordClick(shortURL, referer, userAgent, ip string) {
+	click := models.Click{
+		Time:      time.Now().UTC(),
+		ShortURL:  shortURL,
+		Referer:   referer,
+		UserAgent: userAgent,
+		IPHash:    hashIP(sh.ipKey, ip),
+	}
+
+	select {
+	case sh.clicks <- click:
+	default:
+		sh.logger.Sugar().Warnf("click queue is full, click on %s dropped", shortURL)
+	}
+}
+
+// RunClickRecorder saves queued clicks in batches until ctx is done, then
+// flushes whatever is left.
+func (sh *Shortener) RunClickRecorder(ctx context.Context, flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Click, 0, clickBatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := sh.store.SaveClicks(ctx, batch); err != nil {
+			sh.logger.Sugar().Errorf("failed to save %d clicks: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), clickFlushWait)
+			for len(sh.clicks) > 0 {
+				batch = append(batch, <-sh.clicks)
+			}
+			flush(flushCtx)
+			cancel()
+			return
+		case click := <-sh.clicks:
+			batch = append(batch, click)
+			if len(batch) >= clickBatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+func (sh *Shortener) GetClickStats(ctx context.Context, userID string, shortURL string) (models.URLStats, error) {
+	ctx, span := tracer.Start(ctx, "shortener.GetClickStats")
+	defer span.End()
+
+	owned, err := sh.store.GetURLByUserID(ctx, models.URLQuery{UserID: userID, ShortURL: shortURL, Limit: 1})
+	if err != nil {
+		return models.URLStats{}, fmt.Errorf("failed to check owner of short_url=%s: %w", shortURL, err)
+	}
+	if len(owned) == 0 {
+		return models.URLStats{}, fmt.Errorf("short_url=%s for user %s: %w", shortURL, userID, myErrors.ErrURLNotFound)
+	}
+
+	stats, err := sh.store.GetClickStats(ctx, shortURL)
+	if err != nil {
+		return models.URLStats{}, fmt.Errorf("failed to get click stats: %w", err)
+	}
+	return stats, nil
+}
+
+// hashIP is the HMAC-SHA256 of ip, so that the hashes can't be reversed by
+// hashing all the addresses without the key.
+func hashIP(key []byte, ip string) string {
+	if ip == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newIPKey() []byte {
+	return securecookie.GenerateRandomKey(ipKeyLength)
+}
@@ -0,0 +1,59 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+)
+
+// ExpirationTime converts the optional expires_at/ttl_seconds pair of a request
+// into an absolute expiry. Zero time means the link never expires.
+func ExpirationTime(expiresAt *time.Time, ttlSeconds int64) (time.Time, error) {
+	if expiresAt != nil && ttlSeconds != 0 {
+		return time.Time{}, fmt.Errorf("expires_at and ttl_seconds are mutually exclusive: %w",
+			myErrors.ErrInvalidExpiration)
+	}
+
+	if ttlSeconds < 0 {
+		return time.Time{}, fmt.Errorf("ttl_seconds must be positive: %w", myErrors.ErrInvalidExpiration)
+	}
+
+	if ttlSeconds > 0 {
+		return time.Now().Add(time.Duration(ttlSeconds) * time.Second), nil
+	}
+
+	if expiresAt == nil {
+		return time.Time{}, nil
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("expires_at %s is in the past: %w",
+			expiresAt.Format(time.RFC3339), myErrors.ErrInvalidExpiration)
+	}
+
+	return *expiresAt, nil
+}
+
+// RunExpirySweeper periodically flags expired links as deleted until ctx is done.
+func (sh *Shortener) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := sh.store.FlagExpired(ctx)
+			if err != nil {
+				sh.logger.Sugar().Errorf("failed to flag expired urls: %v", err)
+				continue
+			}
+			if count > 0 {
+				sh.logger.Sugar().Infof("flagged %d expired urls", count)
+			}
+		}
+	}
+}
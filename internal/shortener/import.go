@@ -0,0 +1,304 @@
+package shortener
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+)
+
+const (
+	// importChunkSize is the count of lines saved with one SaveURLBatch.
+	importChunkSize = 1000
+	// maxImportLine bounds a line of JSON Lines import.
+	maxImportLine = 64 * 1024
+	// maxImportURLLength is the length of urls.full_url in the DB.
+	maxImportURLLength = 200
+
+	ImportCreated  = "created"
+	ImportConflict = "conflict"
+	ImportInvalid  = "invalid"
+)
+
+// ImportReader yields the records of an import one by one and io.EOF at the
+// end. Malformed lines are returned as records with Err set.
+type ImportReader interface {
+	Next() (models.ImportRecord, error)
+}
+
+type csvImportReader struct {
+	reader *csv.Reader
+	line   int
+}
+
+// NewCSVImportReader reads lines of original_url[,alias]. A header line
+// starting with original_url is skipped.
+func NewCSVImportReader(r io.Reader) ImportReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+	return &csvImportReader{reader: reader}
+}
+
+func (r *csvImportReader) Next() (models.ImportRecord, error) {
+	for {
+		fields, err := r.reader.Read()
+		if errors.Is(err, io.EOF) {
+			return models.ImportRecord{}, io.EOF
+		}
+		r.line++
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return models.ImportRecord{}, fmt.Errorf("failed to read CSV: %w", err)
+			}
+			return models.ImportRecord{Line: r.line, Err: parseErr.Err}, nil
+		}
+
+		if r.line == 1 && strings.EqualFold(strings.TrimSpace(fields[0]), "original_url") {
+			continue
+		}
+
+		record := models.ImportRecord{Line: r.line, FullURL: strings.TrimSpace(fields[0])}
+		switch len(fields) {
+		case 1:
+		case 2:
+			record.Alias = strings.TrimSpace(fields[1])
+		default:
+			record.Err = fmt.Errorf("expected 1 or 2 fields, got %d", len(fields))
+		}
+		return record, nil
+	}
+}
+
+type jsonlImportReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewJSONLImportReader reads lines of {"original_url": ..., "alias": ...}.
+// Empty lines are skipped.
+func NewJSONLImportReader(r io.Reader) ImportReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxImportLine)
+	return &jsonlImportReader{scanner: scanner}
+}
+
+func (r *jsonlImportReader) Next() (models.ImportRecord, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record models.ImportRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return models.ImportRecord{Line: r.line, Err: fmt.Errorf("invalid JSON: %w", err)}, nil
+		}
+		record.Line = r.line
+		return record, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return models.ImportRecord{}, fmt.Errorf("failed to read line %d: %w", r.line+1, err)
+	}
+	return models.ImportRecord{}, io.EOF
+}
+
+// Import saves the records of reader in chunks and reports the result of
+// every line to emit in the order of lines. A chunk is saved with one
+// SaveURLBatch and falls back to saving line by line when the batch fails, so
+// a bad line doesn't fail its neighbours. The import stops on the first
+// error of the store or of emit.
+func (sh *Shortener) Import(
+	ctx context.Context,
+	userID string,
+	reader ImportReader,
+	emit func(models.ImportResult) error,
+) error {
+	ctx, span := tracer.Start(ctx, "shortener.Import")
+	defer span.End()
+
+	chunk := make([]models.ImportRecord, 0, importChunkSize)
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		chunk = append(chunk, record)
+		if len(chunk) == importChunkSize {
+			if err := sh.importChunk(ctx, userID, chunk, emit); err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+		}
+	}
+
+	return sh.importChunk(ctx, userID, chunk, emit)
+}
+
+func (sh *Shortener) importChunk(
+	ctx context.Context,
+	userID string,
+	chunk []models.ImportRecord,
+	emit func(models.ImportResult) error,
+) error {
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	results := make([]models.ImportResult, len(chunk))
+	urls := make(map[string]string, len(chunk))
+	firstLines := make(map[string]int, len(chunk))
+	// repeats maps a line to the earlier one of the same full URL, whose short
+	// URL is known only once the chunk is saved.
+	repeats := make(map[int]int)
+	pending := make([]int, 0, len(chunk))
+
+	for i, record := range chunk {
+		results[i] = models.ImportResult{Line: record.Line, OriginalURL: record.FullURL}
+
+		if err := checkImportRecord(record); err != nil {
+			results[i].Status, results[i].Error = ImportInvalid, err.Error()
+			continue
+		}
+
+		if first, found := firstLines[record.FullURL]; found {
+			repeats[i] = first
+			continue
+		}
+
+		shortURL := record.Alias
+		if shortURL == "" {
+			generated, err := sh.generateUnique(urls)
+			if err != nil {
+				return err
+			}
+			shortURL = generated
+		}
+		if _, found := urls[shortURL]; found {
+			results[i].Status = ImportConflict
+			results[i].Error = myErrors.ErrAliasAlreadyExists.Error()
+			continue
+		}
+
+		urls[shortURL] = record.FullURL
+		firstLines[record.FullURL] = i
+		results[i].ShortURL = shortURL
+		pending = append(pending, i)
+	}
+
+	err := sh.store.SaveURLBatch(ctx, urls, userID, nil)
+	if err == nil {
+		for _, i := range pending {
+			results[i].Status = ImportCreated
+		}
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("failed to import: %w", ctx.Err())
+		}
+		for _, i := range pending {
+			sh.importOne(ctx, userID, chunk[i], &results[i])
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("failed to import: %w", ctx.Err())
+		}
+	}
+
+	for i, first := range repeats {
+		results[i].Status, results[i].ShortURL = ImportConflict, results[first].ShortURL
+		if results[first].ShortURL == "" {
+			results[i].Status, results[i].Error = results[first].Status, results[first].Error
+		}
+	}
+
+	for _, result := range results {
+		if err := emit(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateUnique generates a short URL not taken in the chunk yet.
+func (sh *Shortener) generateUnique(urls map[string]string) (string, error) {
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		shortURL, err := sh.generator.Generate()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate short URL: %w", err)
+		}
+		if _, found := urls[shortURL]; !found {
+			return shortURL, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate short URL in %d attempts: %w",
+		maxGenerateAttempts, myErrors.ErrKeyAlreadyExists)
+}
+
+// importOne saves record alone and sets the status of result. A record the
+// store fails to save is reported invalid, so one bad line doesn't end the
+// whole import.
+func (sh *Shortener) importOne(
+	ctx context.Context,
+	userID string,
+	record models.ImportRecord,
+	result *models.ImportResult,
+) {
+	var shortURL string
+	var err error
+	if record.Alias != "" {
+		shortURL, err = sh.GetShortURLWithAlias(ctx, record.FullURL, record.Alias, userID, models.URLMeta{})
+	} else {
+		shortURL, err = sh.GetShortURL(ctx, record.FullURL, userID, models.URLMeta{})
+	}
+
+	result.ShortURL = shortURL
+	switch {
+	case err == nil:
+		result.Status = ImportCreated
+	case errors.Is(err, myErrors.ErrURLAlreadySaved):
+		result.Status, result.Error = ImportConflict, myErrors.ErrURLAlreadySaved.Error()
+	case errors.Is(err, myErrors.ErrAliasAlreadyExists):
+		result.Status, result.Error = ImportConflict, myErrors.ErrAliasAlreadyExists.Error()
+	default:
+		sh.logger.Sugar().Errorf("failed to import line %d: %v", record.Line, err)
+		result.ShortURL = ""
+		result.Status, result.Error = ImportInvalid, "failed to save URL"
+	}
+}
+
+func checkImportRecord(record models.ImportRecord) error {
+	if record.Err != nil {
+		return record.Err
+	}
+
+	if _, err := url.ParseRequestURI(record.FullURL); err != nil {
+		return fmt.Errorf("%q is not URL", record.FullURL)
+	}
+
+	if utf8.RuneCountInString(record.FullURL) > maxImportURLLength {
+		return fmt.Errorf("URL is longer than %d characters", maxImportURLLength)
+	}
+
+	if record.Alias != "" {
+		return checkAlias(record.Alias)
+	}
+	return nil
+}
@@ -2,44 +2,133 @@ package shortener
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
-	"sync"
 	"time"
 
 	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/idgen"
 	"github.com/tiunovvv/go-yandex-shortener/internal/models"
 	"github.com/tiunovvv/go-yandex-shortener/internal/storage"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("github.com/tiunovvv/go-yandex-shortener/internal/shortener")
+
+const (
+	// maxGenerateAttempts bounds the number of short URLs tried when the
+	// generated ones collide with already saved keys.
+	maxGenerateAttempts  = 5
+	defaultDeleteWorkers = 3
+)
+
 type Shortener struct {
-	store  storage.Store
-	logger *zap.Logger
+	store     storage.Store
+	generator idgen.IDGenerator
+	logger    *zap.Logger
+	clicks    chan models.Click
+	deleter   *Deleter
+	backend   string
+	ipKey     []byte
 }
 
-func NewShortener(store storage.Store, logger *zap.Logger) *Shortener {
+func NewShortener(store storage.Store, generator idgen.IDGenerator, logger *zap.Logger) *Shortener {
 	return &Shortener{
-		store:  store,
-		logger: logger,
+		store:     store,
+		generator: generator,
+		logger:    logger,
+		clicks:    make(chan models.Click, clickQueueSize),
+		deleter:   NewDeleter(store, defaultDeleteWorkers, logger),
+		ipKey:     newIPKey(),
+	}
+}
+
+// SetDeleteWorkers changes the count of delete workers. It has effect only
+// before Start.
+func (sh *Shortener) SetDeleteWorkers(count int) {
+	if count > 0 {
+		sh.deleter.workers = count
 	}
 }
 
-func (sh *Shortener) GetShortURL(ctx context.Context, fullURL string, userID string) (string, error) {
-	shortURL := generateShortURL()
-	err := sh.store.SaveURL(ctx, shortURL, fullURL, userID)
-	if errors.Is(err, myErrors.ErrURLAlreadySaved) {
-		shortURL := sh.store.GetShortURL(ctx, fullURL)
-		return shortURL, myErrors.ErrURLAlreadySaved
+// SetClickIPKey sets the key of the IP hashes of clicks. Until it is set, a
+// random key is used.
+func (sh *Shortener) SetClickIPKey(key []byte) {
+	if len(key) != 0 {
+		sh.ipKey = key
 	}
+}
+
+// SetBackend names the storage backend reported by Backend.
+func (sh *Shortener) SetBackend(backend string) {
+	sh.backend = backend
+}
+
+// Backend names the storage backend the urls are kept in.
+func (sh *Shortener) Backend() string {
+	return sh.backend
+}
+
+// SetDeleteBatch changes the size and the flush interval of deletion batches.
+// It has effect only before Start.
+func (sh *Shortener) SetDeleteBatch(size int, interval time.Duration) {
+	sh.deleter.SetBatch(size, interval)
+}
+
+// DeleteQueueStats reports the state of the deletion queue.
+func (sh *Shortener) DeleteQueueStats() models.DeleteQueueStats {
+	return sh.deleter.Stats()
+}
+
+// Start runs the background deletion service.
+func (sh *Shortener) Start() {
+	sh.deleter.Start()
+}
+
+// Stop waits for the queued deletions until ctx is done.
+func (sh *Shortener) Stop(ctx context.Context) error {
+	return sh.deleter.Stop(ctx)
+}
+
+// GetShortURL saves fullURL with meta under a generated short URL.
+func (sh *Shortener) GetShortURL(
+	ctx context.Context,
+	fullURL string,
+	userID string,
+	meta models.URLMeta,
+) (string, error) {
+	ctx, span := tracer.Start(ctx, "shortener.GetShortURL")
+	defer span.End()
+
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		shortURL, err := sh.generator.Generate()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate short URL: %w", err)
+		}
+
+		err = sh.store.SaveURL(ctx, shortURL, fullURL, userID, meta)
+		if errors.Is(err, myErrors.ErrURLAlreadySaved) {
+			shortURL := sh.store.GetShortURL(ctx, fullURL)
+			return shortURL, myErrors.ErrURLAlreadySaved
+		}
+
+		if errors.Is(err, myErrors.ErrKeyAlreadyExists) {
+			sh.logger.Sugar().Infof("short URL %s is taken, attempt %d", shortURL, attempt+1)
+			continue
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("failed to save short URL: %w", err)
+		}
 
-	for errors.Is(err, myErrors.ErrKeyAlreadyExists) {
-		shortURL = generateShortURL()
-		err = sh.store.SaveURL(ctx, shortURL, fullURL, userID)
+		return shortURL, nil
 	}
 
-	return shortURL, nil
+	return "", fmt.Errorf("failed to generate short URL in %d attempts: %w",
+		maxGenerateAttempts, myErrors.ErrKeyAlreadyExists)
 }
 
 func (sh *Shortener) GetShortURLBatch(
@@ -47,22 +136,70 @@ func (sh *Shortener) GetShortURLBatch(
 	reqSlice []models.ReqAPIBatch,
 	userID string,
 ) ([]models.ResAPIBatch, error) {
-	urls := make(map[string]string)
-	resSlice := make([]models.ResAPIBatch, 0, len(reqSlice))
+	ctx, span := tracer.Start(ctx, "shortener.GetShortURLBatch")
+	defer span.End()
+
+	meta := make([]models.URLMeta, 0, len(reqSlice))
 	for _, req := range reqSlice {
-		res := models.ResAPIBatch{ID: req.ID, ShortURL: generateShortURL()}
-		resSlice = append(resSlice, res)
-		urls[res.ShortURL] = req.FullURL
+		expiresAt, err := ExpirationTime(req.ExpiresAt, req.TTLSeconds)
+		if err != nil {
+			return nil, fmt.Errorf("correlation_id %s: %w", req.ID, err)
+		}
+		if err := ValidateTitle(req.Title); err != nil {
+			return nil, fmt.Errorf("correlation_id %s: %w", req.ID, err)
+		}
+		reqTags, err := NormalizeTags(req.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("correlation_id %s: %w", req.ID, err)
+		}
+		meta = append(meta, models.URLMeta{ExpiresAt: expiresAt, Title: req.Title, Tags: reqTags})
 	}
 
-	if err := sh.store.SaveURLBatch(ctx, urls, userID); err != nil {
-		return nil, fmt.Errorf("failed to save URL Batch: %w", err)
+	return sh.saveBatch(ctx, reqSlice, meta, userID)
+}
+
+// saveBatch saves the urls of reqSlice with their meta under generated short
+// URLs. The batch is saved entirely or not at all, so it is generated anew
+// when a short URL is taken.
+func (sh *Shortener) saveBatch(
+	ctx context.Context,
+	reqSlice []models.ReqAPIBatch,
+	meta []models.URLMeta,
+	userID string,
+) ([]models.ResAPIBatch, error) {
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		urls := make(map[string]string, len(reqSlice))
+		urlsMeta := make(map[string]models.URLMeta, len(reqSlice))
+		resSlice := make([]models.ResAPIBatch, 0, len(reqSlice))
+		for i, req := range reqSlice {
+			shortURL, err := sh.generateUnique(urls)
+			if err != nil {
+				return nil, err
+			}
+			urls[shortURL] = req.FullURL
+			urlsMeta[shortURL] = meta[i]
+			resSlice = append(resSlice, models.ResAPIBatch{ID: req.ID, ShortURL: shortURL})
+		}
+
+		err := sh.store.SaveURLBatch(ctx, urls, userID, urlsMeta)
+		if errors.Is(err, myErrors.ErrKeyAlreadyExists) {
+			sh.logger.Sugar().Infof("short URL of batch is taken, attempt %d", attempt+1)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save URL Batch: %w", err)
+		}
+		return resSlice, nil
 	}
 
-	return resSlice, nil
+	return nil, fmt.Errorf("failed to generate short URL batch in %d attempts: %w",
+		maxGenerateAttempts, myErrors.ErrKeyAlreadyExists)
 }
 
 func (sh *Shortener) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
+	ctx, span := tracer.Start(ctx, "shortener.GetFullURL")
+	defer span.End()
+
 	fullURL, deleteFlag, err := sh.store.GetFullURL(ctx, shortURL)
 	if err != nil {
 		return "", false, fmt.Errorf("failed to get fullURL from filestore: %w", err)
@@ -70,54 +207,119 @@ func (sh *Shortener) GetFullURL(ctx context.Context, shortURL string) (string, b
 	return fullURL, deleteFlag, nil
 }
 
-func (sh *Shortener) GetURLByUserID(ctx context.Context, baseURL string, userID string) []models.UsersURLs {
-	urls := sh.store.GetURLByUserID(ctx, userID)
-	userURLs := make([]models.UsersURLs, 0, len(urls))
-	for k, v := range urls {
-		userURL := models.UsersURLs{ShortURL: fmt.Sprintf("%s/%s", baseURL, k), OriginalURL: v}
-		userURLs = append(userURLs, userURL)
+// GetURLByUserID returns a page of urls selected by query with short URLs
+// prefixed by baseURL. An empty cursor starts from the first page; the
+// returned cursor continues from the last url and is empty on the last page.
+func (sh *Shortener) GetURLByUserID(
+	ctx context.Context,
+	baseURL string,
+	query models.URLQuery,
+	cursor string,
+) ([]models.UsersURLs, string, error) {
+	ctx, span := tracer.Start(ctx, "shortener.GetURLByUserID")
+	defer span.End()
+
+	if cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query.After = &after
+	}
+
+	limit := query.Limit
+	if limit > 0 {
+		query.Limit++
+	}
+	urls, err := sh.store.GetURLByUserID(ctx, query)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get urls of user %s: %w", query.UserID, err)
+	}
+
+	var next string
+	if limit > 0 && len(urls) > limit {
+		urls = urls[:limit]
+		last := urls[limit-1]
+		next = encodeCursor(models.URLCursor{CreatedAt: last.CreatedAt, ShortURL: last.ShortURL})
+	}
+
+	for i := range urls {
+		urls[i].ShortURL = fmt.Sprintf("%s/%s", baseURL, urls[i].ShortURL)
+	}
+	return urls, next, nil
+}
+
+func encodeCursor(cursor models.URLCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (models.URLCursor, error) {
+	var after models.URLCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return after, fmt.Errorf("failed to decode cursor %q: %w", cursor, myErrors.ErrInvalidCursor)
+	}
+	if err := json.Unmarshal(data, &after); err != nil || after.ShortURL == "" {
+		return after, fmt.Errorf("failed to parse cursor %q: %w", cursor, myErrors.ErrInvalidCursor)
+	}
+	return after, nil
+}
+
+// ExportURLs passes the urls of userID to yield with short URLs prefixed by
+// baseURL.
+func (sh *Shortener) ExportURLs(
+	ctx context.Context,
+	baseURL string,
+	userID string,
+	yield func(models.ExportedURL) error,
+) error {
+	ctx, span := tracer.Start(ctx, "shortener.ExportURLs")
+	defer span.End()
+
+	err := sh.store.ExportURLs(ctx, userID, func(url models.ExportedURL) error {
+		url.ShortURL = fmt.Sprintf("%s/%s", baseURL, url.ShortURL)
+		return yield(url)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export urls: %w", err)
 	}
-	return userURLs
+	return nil
 }
 
 func (sh *Shortener) CheckConnect(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "shortener.CheckConnect")
+	defer span.End()
+
 	if err := sh.store.GetPing(ctx); err != nil {
 		return fmt.Errorf("failed to connect store: %w", err)
 	}
 	return nil
 }
 
-func (sh *Shortener) SetDeletedFlag(ctx context.Context, userID string, shortURLSlice []string) {
-	const countOfWorkers = 3
-	jobQueue := make(chan Job, countOfWorkers)
-	dispatcher := NewDispatcher(ctx, countOfWorkers, jobQueue, sh.store, sh.logger)
+func (sh *Shortener) SetDeletedFlag(ctx context.Context, userID string, shortURLSlice []string) error {
+	ctx, span := tracer.Start(ctx, "shortener.SetDeletedFlag")
+	defer span.End()
 
-	go func() {
-		var wg sync.WaitGroup
-		for _, shortURL := range shortURLSlice {
-			dispatcher.jobQueue <- Job{userID: userID, shortURL: shortURL}
-		}
-		close(dispatcher.jobQueue)
-		for _, worker := range dispatcher.workerPool {
-			wg.Add(1)
-			go func(w *Worker) {
-				defer wg.Done()
-				w.Start(ctx)
-			}(worker)
-		}
-		wg.Wait()
-	}()
+	if err := sh.deleter.Enqueue(ctx, userID, shortURLSlice); err != nil {
+		return fmt.Errorf("failed to delete urls: %w", err)
+	}
+	return nil
 }
 
-func generateShortURL() string {
-	rand.New(rand.NewSource(time.Now().UnixNano()))
-	const length = 8
-	str := make([]byte, length)
+func (sh *Shortener) GetStats(ctx context.Context) (models.Stats, error) {
+	ctx, span := tracer.Start(ctx, "shortener.GetStats")
+	defer span.End()
 
-	charset := "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	for i := range str {
-		str[i] = charset[rand.Intn(len(charset))]
+	urls, err := sh.store.GetURLsCount(ctx)
+	if err != nil {
+		return models.Stats{}, fmt.Errorf("failed to count urls: %w", err)
+	}
+
+	users, err := sh.store.GetUsersCount(ctx)
+	if err != nil {
+		return models.Stats{}, fmt.Errorf("failed to count users: %w", err)
 	}
 
-	return string(str)
+	return models.Stats{URLs: urls, Users: users, DeleteQueue: sh.DeleteQueueStats()}, nil
 }
@@ -2,60 +2,277 @@ package shortener
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
 	"github.com/tiunovvv/go-yandex-shortener/internal/storage"
 	"go.uber.org/zap"
 )
 
+const (
+	// deleteQueueSize is the count of delete requests buffered before
+	// SetDeletedFlag starts to wait for the collector.
+	deleteQueueSize = 1024
+	// defaultDeleteBatchSize is the count of short URLs flushed in one batch.
+	defaultDeleteBatchSize = 1000
+	defaultDeleteInterval  = time.Second
+)
+
 type Job struct {
-	userID   string
-	shortURL string
+	userID    string
+	shortURLs []string
 }
 
+// Batch holds the short URLs to be deleted grouped by user.
+type Batch map[string][]string
+
 type Worker struct {
-	jobQueue chan Job
-	logger   *zap.Logger
-	store    storage.Store
-	id       int
+	batches <-chan Batch
+	logger  *zap.Logger
+	store   storage.Store
+	stats   *deleteStats
+	id      int
 }
 
-type Dispatcher struct {
-	jobQueue   chan Job
-	workerPool []*Worker
+type deleteStats struct {
+	queued  atomic.Int64
+	deleted atomic.Int64
+	failed  atomic.Int64
+	batches atomic.Int64
 }
 
-func NewWorker(id int, jobQueue chan Job, store storage.Store, logger *zap.Logger) *Worker {
+// Deleter is a process-wide fan-in queue of deletions. Requests of all users
+// are collected into batches, which are flushed by size or by interval with
+// one query per user. Jobs are accepted between Start and Stop, Stop flushes
+// the jobs already queued.
+type Deleter struct {
+	store     storage.Store
+	logger    *zap.Logger
+	jobQueue  chan Job
+	batches   chan Batch
+	stopping  chan struct{}
+	cancel    context.CancelFunc
+	stats     deleteStats
+	wg        sync.WaitGroup
+	senders   sync.WaitGroup
+	mu        sync.RWMutex
+	interval  time.Duration
+	workers   int
+	batchSize int
+	running   bool
+	stopped   bool
+}
+
+func NewWorker(id int, batches <-chan Batch, store storage.Store, stats *deleteStats, logger *zap.Logger) *Worker {
 	return &Worker{
-		id:       id,
-		jobQueue: jobQueue,
-		store:    store,
-		logger:   logger,
+		id:      id,
+		batches: batches,
+		store:   store,
+		stats:   stats,
+		logger:  logger,
 	}
 }
 
-func NewDispatcher(
-	ctx context.Context,
-	workerCount int,
-	jobQueue chan Job,
-	store storage.Store,
-	logger *zap.Logger) *Dispatcher {
-	workerPool := make([]*Worker, workerCount)
-	for i := 0; i < workerCount; i++ {
-		worker := NewWorker(i, jobQueue, store, logger)
-		workerPool[i] = worker
-		go worker.Start(ctx)
+func NewDeleter(store storage.Store, workers int, logger *zap.Logger) *Deleter {
+	return &Deleter{
+		store:     store,
+		logger:    logger,
+		workers:   workers,
+		batchSize: defaultDeleteBatchSize,
+		interval:  defaultDeleteInterval,
+		jobQueue:  make(chan Job, deleteQueueSize),
+		batches:   make(chan Batch),
+		stopping:  make(chan struct{}),
 	}
+}
 
-	return &Dispatcher{
-		workerPool: workerPool,
-		jobQueue:   jobQueue,
+func (w *Worker) Start(ctx context.Context) {
+	for batch := range w.batches {
+		if ctx.Err() != nil {
+			return
+		}
+		w.stats.batches.Add(1)
+		for userID, shortURLs := range batch {
+			count := int64(len(shortURLs))
+			if err := w.store.SetDeletedFlag(ctx, userID, shortURLs); err != nil {
+				w.stats.failed.Add(count)
+				w.logger.Sugar().Errorf("worker %d failed to set deleted flag: %v", w.id, err)
+			} else {
+				w.stats.deleted.Add(count)
+			}
+			w.stats.queued.Add(-count)
+		}
 	}
 }
 
-func (w *Worker) Start(ctx context.Context) {
-	for job := range w.jobQueue {
-		if err := w.store.SetDeletedFlag(ctx, job.userID, job.shortURL); err != nil {
-			w.logger.Sugar().Errorf("failed to set deleted flag: %w", err)
+// SetBatch changes the batch size and the flush interval. It has effect only
+// before Start.
+func (d *Deleter) SetBatch(size int, interval time.Duration) {
+	if size > 0 {
+		d.batchSize = size
+	}
+	if interval > 0 {
+		d.interval = interval
+	}
+}
+
+// Start runs the collector and the workers. Batches are processed with a
+// context of their own, so they are not cancelled together with the requests
+// that queued them.
+func (d *Deleter) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running || d.stopped {
+		return
+	}
+	d.running = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	var workers sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		worker := NewWorker(i, d.batches, d.store, &d.stats, d.logger)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker.Start(ctx)
+		}()
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.collect(ctx)
+		close(d.batches)
+		workers.Wait()
+	}()
+}
+
+// collect fans in the queued jobs until the deleter is stopping, then takes
+// the jobs of the senders still in Enqueue and flushes. Batches are dropped
+// once ctx is done.
+func (d *Deleter) collect(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	batch := make(Batch)
+	size := 0
+	flush := func() {
+		if size == 0 {
+			return
+		}
+		select {
+		case d.batches <- batch:
+		case <-ctx.Done():
 		}
+		batch = make(Batch)
+		size = 0
+	}
+
+	add := func(job Job) {
+		batch[job.userID] = append(batch[job.userID], job.shortURLs...)
+		size += len(job.shortURLs)
+		if size >= d.batchSize {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case job := <-d.jobQueue:
+			add(job)
+		case <-ticker.C:
+			flush()
+		case <-d.stopping:
+			d.senders.Wait()
+			for {
+				select {
+				case job := <-d.jobQueue:
+					add(job)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Enqueue queues deletion of shortURLs. It waits while the queue is full and
+// fails once the deleter is stopped.
+func (d *Deleter) Enqueue(ctx context.Context, userID string, shortURLs []string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+
+	d.mu.RLock()
+	if !d.running || d.stopped {
+		d.mu.RUnlock()
+		return myErrors.ErrShuttingDown
+	}
+	d.senders.Add(1)
+	d.mu.RUnlock()
+	defer d.senders.Done()
+
+	count := int64(len(shortURLs))
+	d.stats.queued.Add(count)
+	select {
+	case d.jobQueue <- Job{userID: userID, shortURLs: shortURLs}:
+		return nil
+	case <-d.stopping:
+		d.stats.queued.Add(-count)
+		return myErrors.ErrShuttingDown
+	case <-ctx.Done():
+		d.stats.queued.Add(-count)
+		return fmt.Errorf("failed to queue deletion: %w", ctx.Err())
+	}
+}
+
+// Stats returns the queue depth and the totals of processed short URLs.
+func (d *Deleter) Stats() models.DeleteQueueStats {
+	return models.DeleteQueueStats{
+		Depth:   d.stats.queued.Load(),
+		Deleted: d.stats.deleted.Load(),
+		Failed:  d.stats.failed.Load(),
+		Batches: d.stats.batches.Load(),
+	}
+}
+
+// Stop stops accepting jobs and waits for the queued ones to be flushed.
+// When ctx is done first, the batches in progress are cancelled.
+func (d *Deleter) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return nil
+	}
+	d.stopped = true
+	close(d.stopping)
+	running := d.running
+	d.mu.Unlock()
+
+	if !running {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return fmt.Errorf("deletion is interrupted, %d urls are lost: %w", d.stats.queued.Load(), ctx.Err())
 	}
 }
@@ -0,0 +1,396 @@
+package shortener
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/idgen"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	"github.com/tiunovvv/go-yandex-shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+type constGenerator struct {
+	calls int
+}
+
+func (g *constGenerator) Generate() (string, error) {
+	g.calls++
+	return "constant", nil
+}
+
+func TestGetShortURLBoundedRetries(t *testing.T) {
+	generator := &constGenerator{}
+	sh := NewShortener(storage.NewMemory(), generator, zap.NewNop())
+	ctx := context.Background()
+
+	shortURL, err := sh.GetShortURL(ctx, "http://yandex.ru", "user", models.URLMeta{})
+	require.NoError(t, err)
+	assert.Equal(t, "constant", shortURL)
+
+	_, err = sh.GetShortURL(ctx, "http://google.ru", "user", models.URLMeta{})
+	assert.ErrorIs(t, err, myErrors.ErrKeyAlreadyExists)
+	assert.Equal(t, 1+maxGenerateAttempts, generator.calls)
+
+	shortURL, err = sh.GetShortURL(ctx, "http://yandex.ru", "user", models.URLMeta{})
+	assert.ErrorIs(t, err, myErrors.ErrURLAlreadySaved)
+	assert.Equal(t, "constant", shortURL)
+}
+
+// listGenerator returns ids in turn and then "id<n>".
+type listGenerator struct {
+	ids   []string
+	calls int
+}
+
+func (g *listGenerator) Generate() (string, error) {
+	g.calls++
+	if g.calls <= len(g.ids) {
+		return g.ids[g.calls-1], nil
+	}
+	return fmt.Sprintf("id%d", g.calls), nil
+}
+
+func TestGetShortURLBatchRetries(t *testing.T) {
+	store := storage.NewMemory()
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "taken", "http://taken.ru", "other", models.URLMeta{}))
+
+	sh := NewShortener(store, &listGenerator{ids: []string{"free", "taken"}}, zap.NewNop())
+	resSlice, err := sh.GetShortURLBatch(ctx, []models.ReqAPIBatch{
+		{ID: "1", FullURL: "http://first.ru"},
+		{ID: "2", FullURL: "http://second.ru"},
+	}, "user")
+	require.NoError(t, err, "positive test: a taken short URL is generated again")
+	require.Len(t, resSlice, 2)
+	for _, res := range resSlice {
+		assert.NotEqual(t, "taken", res.ShortURL)
+	}
+
+	_, err = NewShortener(store, &constGenerator{}, zap.NewNop()).GetShortURLBatch(ctx,
+		[]models.ReqAPIBatch{{ID: "1", FullURL: "http://third.ru"}}, "user")
+	require.NoError(t, err)
+	_, err = NewShortener(store, &constGenerator{}, zap.NewNop()).GetShortURLBatch(ctx,
+		[]models.ReqAPIBatch{{ID: "1", FullURL: "http://fourth.ru"}}, "user")
+	assert.ErrorIs(t, err, myErrors.ErrKeyAlreadyExists, "negativ test: attempts are bounded")
+}
+
+func TestStopDrainsDeletion(t *testing.T) {
+	store := storage.NewMemory()
+	sh := NewShortener(store, &constGenerator{}, zap.NewNop())
+	sh.SetDeleteWorkers(1)
+	ctx := context.Background()
+
+	shortURLs := []string{"first", "second", "third"}
+	for _, shortURL := range shortURLs {
+		require.NoError(t, store.SaveURL(ctx, shortURL, "http://"+shortURL+".ru", "user", models.URLMeta{}))
+	}
+
+	err := sh.SetDeletedFlag(ctx, "user", shortURLs)
+	assert.ErrorIs(t, err, myErrors.ErrShuttingDown, "negativ test: deletion before start")
+
+	sh.Start()
+	require.NoError(t, sh.SetDeletedFlag(ctx, "user", shortURLs))
+	require.NoError(t, sh.Stop(ctx))
+
+	for _, shortURL := range shortURLs {
+		_, deleted, err := store.GetFullURL(ctx, shortURL)
+		require.NoError(t, err)
+		assert.True(t, deleted, shortURL)
+	}
+
+	err = sh.SetDeletedFlag(ctx, "user", shortURLs)
+	assert.ErrorIs(t, err, myErrors.ErrShuttingDown, "negativ test: deletion after stop")
+}
+
+// blockingStore holds deletions until their context is done.
+type blockingStore struct {
+	storage.Store
+}
+
+func (s *blockingStore) SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStopWithFullQueue(t *testing.T) {
+	d := NewDeleter(&blockingStore{Store: storage.NewMemory()}, 1, zap.NewNop())
+	d.SetBatch(1, time.Hour)
+	d.Start()
+
+	enqueued := make(chan error, 1)
+	go func() {
+		for n := 0; ; n++ {
+			if err := d.Enqueue(context.Background(), "user", []string{fmt.Sprintf("short%d", n)}); err != nil {
+				enqueued <- err
+				return
+			}
+		}
+	}()
+	// One job is in the worker, one in the collector, the queue is full and
+	// one more waits in Enqueue.
+	require.Eventually(t, func() bool { return d.Stats().Depth >= deleteQueueSize+3 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := d.Stop(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "negativ test: Stop is not held by a blocked Enqueue")
+	assert.ErrorIs(t, <-enqueued, myErrors.ErrShuttingDown)
+}
+
+type countingStore struct {
+	storage.Store
+	calls map[string]int
+	mu    sync.Mutex
+}
+
+func (s *countingStore) SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[userID]++
+	return s.Store.SetDeletedFlag(ctx, userID, shortURLs)
+}
+
+func TestDeletionBatches(t *testing.T) {
+	store := &countingStore{Store: storage.NewMemory(), calls: make(map[string]int)}
+	sh := NewShortener(store, &constGenerator{}, zap.NewNop())
+	sh.SetDeleteWorkers(1)
+	sh.SetDeleteBatch(defaultDeleteBatchSize, time.Hour)
+	ctx := context.Background()
+
+	requests := []struct {
+		userID    string
+		shortURLs []string
+	}{
+		{userID: "first", shortURLs: []string{"a", "b"}},
+		{userID: "second", shortURLs: []string{"c"}},
+		{userID: "first", shortURLs: []string{"d", "e"}},
+	}
+	for _, req := range requests {
+		for _, shortURL := range req.shortURLs {
+			require.NoError(t, store.SaveURL(ctx, shortURL, "http://"+shortURL+".ru", req.userID, models.URLMeta{}))
+		}
+	}
+
+	sh.Start()
+	for _, req := range requests {
+		require.NoError(t, sh.SetDeletedFlag(ctx, req.userID, req.shortURLs))
+	}
+	assert.Equal(t, int64(5), sh.deleter.Stats().Depth)
+	require.NoError(t, sh.Stop(ctx))
+
+	assert.Equal(t, map[string]int{"first": 1, "second": 1}, store.calls)
+	stats := sh.deleter.Stats()
+	assert.Equal(t, int64(0), stats.Depth)
+	assert.Equal(t, int64(5), stats.Deleted)
+	assert.Equal(t, int64(1), stats.Batches)
+}
+
+type batchCountingStore struct {
+	storage.Store
+	batches int
+}
+
+func (s *batchCountingStore) SaveURLBatch(
+	ctx context.Context,
+	urls map[string]string,
+	userID string,
+	meta map[string]models.URLMeta,
+) error {
+	s.batches++
+	return s.Store.SaveURLBatch(ctx, urls, userID, meta)
+}
+
+func TestImportChunks(t *testing.T) {
+	store := &batchCountingStore{Store: storage.NewMemory()}
+	sh := NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), zap.NewNop())
+
+	const lines = 2*importChunkSize + 1
+	var body strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&body, "http://site%d.ru\n", i)
+	}
+
+	statuses := make(map[string]int)
+	last := 0
+	err := sh.Import(context.Background(), "user", NewCSVImportReader(strings.NewReader(body.String())),
+		func(result models.ImportResult) error {
+			assert.Equal(t, last+1, result.Line)
+			last = result.Line
+			statuses[result.Status]++
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{ImportCreated: lines}, statuses)
+	assert.Equal(t, 3, store.batches)
+}
+
+// rejectingStore fails to save the URL rejected, as the DB does for values
+// breaking its constraints.
+type rejectingStore struct {
+	storage.Store
+	rejected string
+}
+
+func (s *rejectingStore) SaveURL(
+	ctx context.Context,
+	shortURL string,
+	fullURL string,
+	userID string,
+	meta models.URLMeta,
+) error {
+	if fullURL == s.rejected {
+		return errors.New("value too long")
+	}
+	return s.Store.SaveURL(ctx, shortURL, fullURL, userID, meta)
+}
+
+func (s *rejectingStore) SaveURLBatch(
+	ctx context.Context,
+	urls map[string]string,
+	userID string,
+	meta map[string]models.URLMeta,
+) error {
+	for _, fullURL := range urls {
+		if fullURL == s.rejected {
+			return errors.New("value too long")
+		}
+	}
+	return s.Store.SaveURLBatch(ctx, urls, userID, meta)
+}
+
+func TestImportStoreError(t *testing.T) {
+	store := &rejectingStore{Store: storage.NewMemory(), rejected: "http://rejected.ru"}
+	sh := NewShortener(store, idgen.NewRandom(idgen.DefaultAlphabet, idgen.DefaultLength), zap.NewNop())
+
+	body := "http://first.ru\nhttp://rejected.ru\nhttp://second.ru\n"
+	var results []models.ImportResult
+	err := sh.Import(context.Background(), "user", NewCSVImportReader(strings.NewReader(body)),
+		func(result models.ImportResult) error {
+			results = append(results, result)
+			return nil
+		})
+	require.NoError(t, err, "negativ test: a line the store rejects doesn't end the import")
+	require.Len(t, results, 3)
+	assert.Equal(t, ImportCreated, results[0].Status)
+	assert.Equal(t, ImportInvalid, results[1].Status)
+	assert.NotEmpty(t, results[1].Error)
+	assert.Empty(t, results[1].ShortURL)
+	assert.Equal(t, ImportCreated, results[2].Status)
+}
+
+func TestImportRepeatedURLAfterFailedBatch(t *testing.T) {
+	store := &rejectingStore{Store: storage.NewMemory(), rejected: "http://rejected.ru"}
+	sh := NewShortener(store, &listGenerator{ids: []string{"planned1", "planned2"}}, zap.NewNop())
+	ctx := context.Background()
+
+	body := "http://first.ru\nhttp://rejected.ru\nhttp://first.ru\nhttp://rejected.ru\n"
+	var results []models.ImportResult
+	err := sh.Import(ctx, "user", NewCSVImportReader(strings.NewReader(body)),
+		func(result models.ImportResult) error {
+			results = append(results, result)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+
+	assert.Equal(t, ImportCreated, results[0].Status)
+	assert.NotEqual(t, "planned1", results[0].ShortURL, "the code planned for the batch is not stored")
+	assert.Equal(t, ImportConflict, results[2].Status)
+	assert.Equal(t, results[0].ShortURL, results[2].ShortURL, "positive test: repeat points at the stored code")
+	fullURL, _, err := store.GetFullURL(ctx, results[2].ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, "http://first.ru", fullURL)
+
+	assert.Equal(t, ImportInvalid, results[1].Status)
+	assert.Equal(t, ImportInvalid, results[3].Status, "negativ test: repeat of a line that failed")
+	assert.Empty(t, results[3].ShortURL)
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+		err  error
+	}{
+		{
+			name: "positive test: lower-cased, trimmed, sorted and unique",
+			tags: []string{" Work/Reports ", "home", "work/reports", "Работа"},
+			want: []string{"home", "work/reports", "работа"},
+		},
+		{
+			name: "positive test: no tags",
+			tags: nil,
+			want: []string{},
+		},
+		{
+			name: "negativ test: empty tag",
+			tags: []string{" "},
+			err:  myErrors.ErrInvalidTags,
+		},
+		{
+			name: "negativ test: empty folder",
+			tags: []string{"work//reports"},
+			err:  myErrors.ErrInvalidTags,
+		},
+		{
+			name: "negativ test: forbidden characters",
+			tags: []string{"50%"},
+			err:  myErrors.ErrInvalidTags,
+		},
+		{
+			name: "negativ test: too long",
+			tags: []string{strings.Repeat("a", maxTagLength+1)},
+			err:  myErrors.ErrInvalidTags,
+		},
+		{
+			name: "negativ test: too many",
+			tags: manyTags(maxTags + 1),
+			err:  myErrors.ErrInvalidTags,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTags(tt.tags)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func manyTags(count int) []string {
+	tags := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		tags = append(tags, fmt.Sprintf("tag%d", i))
+	}
+	return tags
+}
+
+func TestHashIP(t *testing.T) {
+	const ip = "192.168.0.1"
+	plain := sha256.Sum256([]byte(ip))
+
+	hash := hashIP([]byte("key"), ip)
+	assert.Equal(t, hash, hashIP([]byte("key"), ip), "positive test: same key, same hash")
+	assert.NotEqual(t, hash, hashIP([]byte("other"), ip), "positive test: the hash depends on the key")
+	assert.NotEqual(t, hex.EncodeToString(plain[:]), hash, "negativ test: the hash is not unsalted sha256")
+	assert.Empty(t, hashIP([]byte("key"), ""))
+}
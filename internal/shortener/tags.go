@@ -0,0 +1,60 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+)
+
+const (
+	maxTags      = 20
+	maxTagLength = 64
+)
+
+// tagPattern allows folders as slash separated segments, e.g. "work/reports".
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]+(/[\p{L}\p{N}_-]+)*$`)
+
+// NormalizeTags lower-cases and trims the tags of a link, drops duplicates
+// and sorts them.
+func NormalizeTags(tags []string) ([]string, error) {
+	set := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if len(tag) > maxTagLength || !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("tag %q must be up to %d letters, digits, '-' or '_' separated by '/': %w",
+				tag, maxTagLength, myErrors.ErrInvalidTags)
+		}
+		set[tag] = struct{}{}
+	}
+
+	if len(set) > maxTags {
+		return nil, fmt.Errorf("link can't have more than %d tags: %w", maxTags, myErrors.ErrInvalidTags)
+	}
+
+	normalized := make([]string, 0, len(set))
+	for tag := range set {
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// SetTags replaces the tags of a link of userID and returns them normalized.
+func (sh *Shortener) SetTags(ctx context.Context, userID string, shortURL string, tags []string) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "shortener.SetTags")
+	defer span.End()
+
+	normalized, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sh.store.SetTags(ctx, userID, shortURL, normalized); err != nil {
+		return nil, fmt.Errorf("failed to set tags: %w", err)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,31 @@
+package shortener
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+)
+
+const maxTitleLength = 256
+
+// ValidateTitle checks a user-supplied title of a link. The empty title means
+// the link has none.
+func ValidateTitle(title string) error {
+	if !utf8.ValidString(title) {
+		return fmt.Errorf("title is not valid UTF-8: %w", myErrors.ErrInvalidTitle)
+	}
+
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Errorf("title is longer than %d characters: %w", maxTitleLength, myErrors.ErrInvalidTitle)
+	}
+
+	for _, r := range title {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("title contains control characters: %w", myErrors.ErrInvalidTitle)
+		}
+	}
+
+	return nil
+}
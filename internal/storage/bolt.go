@@ -0,0 +1,588 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+const (
+	boltOpenTimeout = time.Second
+	// boltExportBatch is the number of urls read by a transaction of export.
+	boltExportBatch = 500
+	// boltKeySeparator splits the parts of the index keys.
+	boltKeySeparator = 0
+	boltSequenceSize = 8
+)
+
+var (
+	// urls maps short URLs to boltURL.
+	boltURLs = []byte("urls")
+	// full_urls maps full URLs to short URLs.
+	boltFullURLs = []byte("full_urls")
+	// user_urls holds keys of user ID, separator and short URL.
+	boltUserURLs = []byte("user_urls")
+	// clicks holds keys of short URL, separator and sequence with clicks as
+	// values.
+	boltClicks = []byte("clicks")
+	// meta holds single values such as the id counter.
+	boltMeta = []byte("meta")
+	// boltNextID is the key of the next value of the id counter in meta.
+	boltNextID = []byte("next_id")
+)
+
+type boltURL struct {
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	FullURL   string     `json:"full_url"`
+	UserID    string     `json:"user_id"`
+	Title     string     `json:"title,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+}
+
+// Bolt keeps urls in a bbolt file. Every method runs in one transaction, so
+// batches are saved entirely or not at all, and the full URL, user and click
+// indexes are buckets with ordered keys.
+type Bolt struct {
+	db     *bolt.DB
+	logger *zap.Logger
+}
+
+func NewBolt(path string, logger *zap.Logger) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt file: %s, %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltURLs, boltFullURLs, boltUserURLs, boltClicks, boltMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+
+	return &Bolt{db: db, logger: logger}, nil
+}
+
+// indexKey joins the parts of an index key.
+func indexKey(prefix string, suffix []byte) []byte {
+	key := make([]byte, 0, len(prefix)+1+len(suffix))
+	key = append(key, prefix...)
+	key = append(key, boltKeySeparator)
+	return append(key, suffix...)
+}
+
+// scanPrefix calls fn for the suffixes of the keys of bucket starting with
+// prefix and the separator, while fn returns true.
+func scanPrefix(bucket *bolt.Bucket, prefix string, fn func(suffix, value []byte) bool) {
+	scanPrefixAfter(bucket, prefix, nil, fn)
+}
+
+// scanPrefixAfter is scanPrefix starting past the suffix after, or from the
+// first suffix when after is empty.
+func scanPrefixAfter(bucket *bolt.Bucket, prefix string, after []byte, fn func(suffix, value []byte) bool) {
+	start := indexKey(prefix, nil)
+	from := indexKey(prefix, after)
+	c := bucket.Cursor()
+	k, v := c.Seek(from)
+	if len(after) > 0 && bytes.Equal(k, from) {
+		k, v = c.Next()
+	}
+	for ; k != nil && bytes.HasPrefix(k, start); k, v = c.Next() {
+		if !fn(k[len(start):], v) {
+			return
+		}
+	}
+}
+
+func getBoltURL(tx *bolt.Tx, shortURL string) (boltURL, bool, error) {
+	var url boltURL
+	data := tx.Bucket(boltURLs).Get([]byte(shortURL))
+	if data == nil {
+		return url, false, nil
+	}
+	if err := json.Unmarshal(data, &url); err != nil {
+		return url, false, fmt.Errorf("failed to unmarshal url %s: %w", shortURL, err)
+	}
+	return url, true, nil
+}
+
+func putBoltURL(tx *bolt.Tx, shortURL string, url boltURL) error {
+	data, err := json.Marshal(url)
+	if err != nil {
+		return fmt.Errorf("failed to marshal url %s: %w", shortURL, err)
+	}
+	if err := tx.Bucket(boltURLs).Put([]byte(shortURL), data); err != nil {
+		return fmt.Errorf("failed to put url %s: %w", shortURL, err)
+	}
+	return nil
+}
+
+// insertBoltURL saves a new url with its indexes.
+func insertBoltURL(tx *bolt.Tx, shortURL string, url boltURL) error {
+	if tx.Bucket(boltFullURLs).Get([]byte(url.FullURL)) != nil {
+		return myErrors.ErrURLAlreadySaved
+	}
+	if tx.Bucket(boltURLs).Get([]byte(shortURL)) != nil {
+		return fmt.Errorf("failed to save shortURL %s: %w", shortURL, myErrors.ErrKeyAlreadyExists)
+	}
+
+	if err := putBoltURL(tx, shortURL, url); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltFullURLs).Put([]byte(url.FullURL), []byte(shortURL)); err != nil {
+		return fmt.Errorf("failed to index full url of %s: %w", shortURL, err)
+	}
+	if err := tx.Bucket(boltUserURLs).Put(indexKey(url.UserID, []byte(shortURL)), nil); err != nil {
+		return fmt.Errorf("failed to index user of %s: %w", shortURL, err)
+	}
+	return nil
+}
+
+// update applies change to the url saved under shortURL in one transaction.
+// The url is kept only when change returns true.
+func (b *Bolt) update(shortURL string, change func(url *boltURL) bool) (bool, error) {
+	var updated bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		url, found, err := getBoltURL(tx, shortURL)
+		if err != nil || !found || !change(&url) {
+			return err
+		}
+		url.UpdatedAt = time.Now()
+		updated = true
+		return putBoltURL(tx, shortURL, url)
+	})
+	if err != nil {
+		return false, err
+	}
+	return updated, nil
+}
+
+func (b *Bolt) GetShortURL(ctx context.Context, fullURL string) string {
+	var shortURL string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		shortURL = string(tx.Bucket(boltFullURLs).Get([]byte(fullURL)))
+		return nil
+	})
+	if err != nil {
+		b.logger.Sugar().Errorf("failed to get short URL: %v", err)
+	}
+	return shortURL
+}
+
+func (b *Bolt) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
+	var url boltURL
+	var found bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		url, found, err = getBoltURL(tx, shortURL)
+		return err
+	})
+	if err != nil {
+		return "", false, err
+	}
+	if !found {
+		return "", false, fmt.Errorf("URL `%s` not found", shortURL)
+	}
+	if url.ExpiresAt != nil && isExpired(*url.ExpiresAt) {
+		return "", false, fmt.Errorf("URL `%s`: %w", shortURL, myErrors.ErrURLExpired)
+	}
+	return url.FullURL, url.Deleted, nil
+}
+
+// newBoltURL is a url saved now with meta.
+func newBoltURL(fullURL string, userID string, meta models.URLMeta) boltURL {
+	now := time.Now()
+	return boltURL{
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: optionalTime(meta.ExpiresAt),
+		Tags:      meta.Tags,
+		FullURL:   fullURL,
+		UserID:    userID,
+		Title:     meta.Title,
+	}
+}
+
+func (b *Bolt) SaveURL(ctx context.Context, shortURL string, fullURL string, userID string, meta models.URLMeta) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return insertBoltURL(tx, shortURL, newBoltURL(fullURL, userID, meta))
+	})
+}
+
+// SaveURLBatch saves all urls or none of them.
+func (b *Bolt) SaveURLBatch(
+	ctx context.Context,
+	urls map[string]string,
+	userID string,
+	meta map[string]models.URLMeta,
+) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for k, v := range urls {
+			if err := insertBoltURL(tx, k, newBoltURL(v, userID, meta[k])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) GetURLByUserID(ctx context.Context, query models.URLQuery) ([]models.UsersURLs, error) {
+	urls := make([]models.UsersURLs, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		shortURLs := []string{query.ShortURL}
+		if query.ShortURL == "" {
+			shortURLs = shortURLs[:0]
+			scanPrefix(tx.Bucket(boltUserURLs), query.UserID, func(suffix, _ []byte) bool {
+				shortURLs = append(shortURLs, string(suffix))
+				return true
+			})
+		}
+
+		for _, key := range shortURLs {
+			value, found, err := getBoltURL(tx, key)
+			if err != nil {
+				return err
+			}
+			if !found ||
+				!matchURLQuery(query, key, value.FullURL, value.UserID, value.Deleted, value.Tags) ||
+				!afterCursor(query, value.CreatedAt, key) {
+				continue
+			}
+			urls = append(urls, models.UsersURLs{
+				ShortURL:    key,
+				OriginalURL: value.FullURL,
+				CreatedAt:   value.CreatedAt,
+				UpdatedAt:   value.UpdatedAt,
+				Tags:        value.Tags,
+				Title:       value.Title,
+				Deleted:     value.Deleted,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get urls by user id: %w", err)
+	}
+	return pageURLs(query, urls), nil
+}
+
+// ExportURLs passes the urls of userID to yield in the order of short URLs.
+// The urls are read in batches and no transaction is open while yield runs.
+func (b *Bolt) ExportURLs(ctx context.Context, userID string, yield func(models.ExportedURL) error) error {
+	after := ""
+	for {
+		batch := make([]models.ExportedURL, 0, boltExportBatch)
+		err := b.db.View(func(tx *bolt.Tx) error {
+			var scanErr error
+			scanPrefixAfter(tx.Bucket(boltUserURLs), userID, []byte(after), func(suffix, _ []byte) bool {
+				shortURL := string(suffix)
+				value, found, err := getBoltURL(tx, shortURL)
+				if err != nil {
+					scanErr = err
+					return false
+				}
+				if found {
+					var clicks int64
+					scanPrefix(tx.Bucket(boltClicks), shortURL, func(_, _ []byte) bool {
+						clicks++
+						return true
+					})
+					createdAt := value.CreatedAt
+					batch = append(batch, models.ExportedURL{
+						CreatedAt:   &createdAt,
+						ShortURL:    shortURL,
+						OriginalURL: value.FullURL,
+						Deleted:     value.Deleted,
+						Clicks:      &clicks,
+					})
+				}
+				after = shortURL
+				return len(batch) < boltExportBatch
+			})
+			return scanErr
+		})
+		if err != nil {
+			return fmt.Errorf("failed to export urls: %w", err)
+		}
+
+		for _, url := range batch {
+			if err := yield(url); err != nil {
+				return err
+			}
+		}
+		if len(batch) < boltExportBatch {
+			return nil
+		}
+	}
+}
+
+// SetDeletedFlag marks the short URLs of userID as deleted. Unknown short URLs
+// and the ones of other users are skipped.
+func (b *Bolt) SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) error {
+	now := time.Now()
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, shortURL := range shortURLs {
+			url, found, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			if !found || url.UserID != userID {
+				continue
+			}
+			url.Deleted = true
+			url.UpdatedAt = now
+			if err := putBoltURL(tx, shortURL, url); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set deleted flag: %w", err)
+	}
+	return nil
+}
+
+// GetExpiration returns the expiration time of a url, zero when it never
+// expires.
+func (b *Bolt) GetExpiration(ctx context.Context, shortURL string) (time.Time, error) {
+	var url boltURL
+	var found bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		url, found, err = getBoltURL(tx, shortURL)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get expiration for short_url=%s: %w", shortURL, err)
+	}
+	if !found {
+		return time.Time{}, fmt.Errorf("URL `%s`: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+	if url.ExpiresAt == nil {
+		return time.Time{}, nil
+	}
+	return *url.ExpiresAt, nil
+}
+
+func (b *Bolt) SetExpiration(ctx context.Context, shortURL string, expiresAt time.Time) error {
+	updated, err := b.update(shortURL, func(url *boltURL) bool {
+		url.ExpiresAt = &expiresAt
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set expiration for short_url=%s: %w", shortURL, err)
+	}
+	if !updated {
+		return fmt.Errorf("failed to set expiration for short_url=%s", shortURL)
+	}
+	return nil
+}
+
+func (b *Bolt) SetTitle(ctx context.Context, shortURL string, title string) error {
+	updated, err := b.update(shortURL, func(url *boltURL) bool {
+		url.Title = title
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set title for short_url=%s: %w", shortURL, err)
+	}
+	if !updated {
+		return fmt.Errorf("failed to set title for short_url=%s: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+	return nil
+}
+
+// SetTags replaces the tags of a url of userID.
+func (b *Bolt) SetTags(ctx context.Context, userID string, shortURL string, tags []string) error {
+	updated, err := b.update(shortURL, func(url *boltURL) bool {
+		if url.UserID != userID {
+			return false
+		}
+		url.Tags = tags
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set tags for short_url=%s: %w", shortURL, err)
+	}
+	if !updated {
+		return fmt.Errorf("failed to set tags for short_url=%s: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+	return nil
+}
+
+func (b *Bolt) FlagExpired(ctx context.Context) (int64, error) {
+	var count int64
+	now := time.Now()
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		expired := make(map[string]boltURL)
+		err := tx.Bucket(boltURLs).ForEach(func(k, v []byte) error {
+			var url boltURL
+			if err := json.Unmarshal(v, &url); err != nil {
+				return fmt.Errorf("failed to unmarshal url %s: %w", k, err)
+			}
+			if !url.Deleted && url.ExpiresAt != nil && isExpired(*url.ExpiresAt) {
+				expired[string(k)] = url
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for shortURL, url := range expired {
+			url.Deleted = true
+			url.UpdatedAt = now
+			if err := putBoltURL(tx, shortURL, url); err != nil {
+				return err
+			}
+		}
+		count = int64(len(expired))
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to flag expired urls: %w", err)
+	}
+	return count, nil
+}
+
+func (b *Bolt) LeaseIDs(ctx context.Context, count uint64) (uint64, error) {
+	var start uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMeta)
+		start = firstID()
+		if data := bucket.Get(boltNextID); data != nil {
+			start = binary.BigEndian.Uint64(data)
+		}
+		next := make([]byte, boltSequenceSize)
+		binary.BigEndian.PutUint64(next, start+count)
+		return bucket.Put(boltNextID, next)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to lease %d ids: %w", count, err)
+	}
+	return start, nil
+}
+
+func (b *Bolt) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltClicks)
+		for _, click := range clicks {
+			data, err := json.Marshal(click)
+			if err != nil {
+				return fmt.Errorf("failed to marshal click %w", err)
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return fmt.Errorf("failed to get click sequence %w", err)
+			}
+			suffix := make([]byte, boltSequenceSize)
+			binary.BigEndian.PutUint64(suffix, seq)
+			if err := bucket.Put(indexKey(click.ShortURL, suffix), data); err != nil {
+				return fmt.Errorf("failed to put click %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save clicks: %w", err)
+	}
+	return nil
+}
+
+func (b *Bolt) GetClickStats(ctx context.Context, shortURL string) (models.URLStats, error) {
+	const dayLayout = "2006-01-02"
+
+	stats := models.URLStats{ShortURL: shortURL, Days: make([]models.DayClicks, 0)}
+	days := make(map[string]int64)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var scanErr error
+		scanPrefix(tx.Bucket(boltClicks), shortURL, func(_, value []byte) bool {
+			click := models.Click{}
+			if err := json.Unmarshal(value, &click); err != nil {
+				scanErr = fmt.Errorf("failed to unmarshal click %w", err)
+				return false
+			}
+			days[click.Time.UTC().Format(dayLayout)]++
+			stats.Total++
+			return true
+		})
+		return scanErr
+	})
+	if err != nil {
+		return models.URLStats{}, fmt.Errorf("failed to get click stats: %w", err)
+	}
+
+	for day, count := range days {
+		stats.Days = append(stats.Days, models.DayClicks{Day: day, Clicks: count})
+	}
+	sort.Slice(stats.Days, func(a, b int) bool { return stats.Days[a].Day < stats.Days[b].Day })
+
+	return stats, nil
+}
+
+func (b *Bolt) GetURLsCount(ctx context.Context) (int, error) {
+	var count int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltFullURLs).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count urls: %w", err)
+	}
+	return count, nil
+}
+
+// GetUsersCount counts the users who saved urls, jumping over the keys of
+// each user in the user index.
+func (b *Bolt) GetUsersCount(ctx context.Context) (int, error) {
+	var count int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltUserURLs).Cursor()
+		for k, _ := c.First(); k != nil; {
+			userID, _, _ := bytes.Cut(k, []byte{boltKeySeparator})
+			if len(userID) != 0 {
+				count++
+			}
+			next := append(append([]byte(nil), userID...), boltKeySeparator+1)
+			k, _ = c.Seek(next)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+func (b *Bolt) GetPing(ctx context.Context) error {
+	if err := b.db.View(func(*bolt.Tx) error { return nil }); err != nil {
+		return fmt.Errorf("failed to ping bolt: %w", err)
+	}
+	return nil
+}
+
+func (b *Bolt) Close() error {
+	if err := b.db.Close(); err != nil {
+		return fmt.Errorf("failed to close bolt: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestBoltReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.db")
+
+	store, err := NewBolt(path, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, "kept", "https://kept.ru", "user1", models.URLMeta{}))
+	require.NoError(t, store.SaveURLBatch(ctx, map[string]string{
+		"deleted": "https://deleted.ru",
+		"tagged":  "https://tagged.ru",
+	}, "user1", nil))
+	require.NoError(t, store.SaveURL(ctx, "other", "https://other.ru", "user2", models.URLMeta{}))
+	require.NoError(t, store.SetDeletedFlag(ctx, "user1", []string{"deleted", "other"}))
+	require.NoError(t, store.SetTags(ctx, "user1", "tagged", []string{"home"}))
+	require.NoError(t, store.SetTitle(ctx, "tagged", "Tagged"))
+	require.NoError(t, store.SaveClicks(ctx, []models.Click{
+		{ShortURL: "kept", Time: time.Now()},
+		{ShortURL: "kept", Time: time.Now()},
+		{ShortURL: "kept-not", Time: time.Now()},
+	}))
+	leased, err := store.LeaseIDs(ctx, 10)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	reopened, err := NewBolt(path, zap.NewNop())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reopened.Close()) }()
+
+	urls, err := reopened.GetURLByUserID(ctx, models.URLQuery{UserID: "user1", Tag: "home"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "Tagged", urls[0].Title)
+
+	_, deleted, err := reopened.GetFullURL(ctx, "deleted")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, deleted, err = reopened.GetFullURL(ctx, "other")
+	require.NoError(t, err)
+	assert.False(t, deleted, "negativ test: url of another user")
+
+	stats, err := reopened.GetClickStats(ctx, "kept")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Total)
+
+	count, err := reopened.GetURLsCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	users, err := reopened.GetUsersCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users)
+
+	next, err := reopened.LeaseIDs(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, leased+10, next)
+}
+
+func TestBoltBatchIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewBolt(filepath.Join(t.TempDir(), "urls.db"), zap.NewNop())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, store.Close()) }()
+
+	require.NoError(t, store.SaveURL(ctx, "saved", "https://saved.ru", "user", models.URLMeta{}))
+	err = store.SaveURLBatch(ctx, map[string]string{
+		"new":   "https://new.ru",
+		"saved": "https://other.ru",
+	}, "user", nil)
+	assert.ErrorIs(t, err, myErrors.ErrKeyAlreadyExists)
+	assert.Empty(t, store.GetShortURL(ctx, "https://new.ru"), "batches are saved entirely or not at all")
+
+	batch := make(map[string]string, boltExportBatch+1)
+	for n := 0; n <= boltExportBatch; n++ {
+		batch[fmt.Sprintf("batch-%04d", n)] = fmt.Sprintf("https://batch%d.ru", n)
+	}
+	require.NoError(t, store.SaveURLBatch(ctx, batch, "user", nil))
+
+	exported := make([]string, 0, len(batch)+1)
+	require.NoError(t, store.ExportURLs(ctx, "user", func(url models.ExportedURL) error {
+		exported = append(exported, url.ShortURL)
+		return nil
+	}))
+	require.Len(t, exported, len(batch)+1)
+	assert.IsIncreasing(t, exported)
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"go.uber.org/zap"
+)
+
+const redisKeyPrefix = "shortener:url:"
+
+// Cache serves GetFullURL from an in-process LRU and then from Redis before
+// asking the wrapped Store. Entries are invalidated on SetDeletedFlag and
+// SetExpiration and live no longer than the link they keep, so expired links
+// are never served. Local entries changed by other instances may still be
+// served for up to ttl. Redis failures are logged and fall through to the
+// Store.
+type Cache struct {
+	Store
+	local  *lruCache
+	redis  *redis.Client
+	logger *zap.Logger
+	ttl    time.Duration
+}
+
+// NewCache wraps store. The local cache is disabled when size is 0, Redis is
+// used when redisURL is not empty.
+func NewCache(store Store, size int, ttl time.Duration, redisURL string, logger *zap.Logger) (*Cache, error) {
+	cache := &Cache{Store: store, ttl: ttl, logger: logger}
+	if size > 0 {
+		cache.local = newLRUCache(size, ttl)
+	}
+
+	if redisURL != "" {
+		options, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis URL: %w", err)
+		}
+		cache.redis = redis.NewClient(options)
+	}
+
+	return cache, nil
+}
+
+func (c *Cache) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
+	if c.local != nil {
+		if value, ok := c.local.Get(shortURL); ok {
+			return cachedResult(shortURL, value)
+		}
+	}
+
+	if value, ok := c.getRemote(ctx, shortURL); ok {
+		if c.local != nil {
+			c.local.Set(shortURL, value)
+		}
+		return cachedResult(shortURL, value)
+	}
+
+	fullURL, deleted, err := c.Store.GetFullURL(ctx, shortURL)
+	if err != nil {
+		return "", false, err
+	}
+
+	expiresAt, err := c.Store.GetExpiration(ctx, shortURL)
+	if err != nil {
+		c.logger.Sugar().Warnf("failed to get expiration of %s, it is not cached: %v", shortURL, err)
+		return fullURL, deleted, nil
+	}
+
+	value := cachedURL{FullURL: fullURL, Deleted: deleted, ExpiresAt: expiresAt}
+	if c.local != nil {
+		c.local.Set(shortURL, value)
+	}
+	c.setRemote(ctx, shortURL, value)
+	return fullURL, deleted, nil
+}
+
+// cachedResult returns a cached url as GetFullURL of the Store would, failing
+// with ErrURLExpired once the url has expired.
+func cachedResult(shortURL string, value cachedURL) (string, bool, error) {
+	if isExpired(value.ExpiresAt) {
+		return "", false, fmt.Errorf("URL `%s`: %w", shortURL, myErrors.ErrURLExpired)
+	}
+	return value.FullURL, value.Deleted, nil
+}
+
+func (c *Cache) SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) error {
+	err := c.Store.SetDeletedFlag(ctx, userID, shortURLs)
+	c.invalidate(ctx, shortURLs...)
+	return err
+}
+
+func (c *Cache) SetExpiration(ctx context.Context, shortURL string, expiresAt time.Time) error {
+	err := c.Store.SetExpiration(ctx, shortURL, expiresAt)
+	c.invalidate(ctx, shortURL)
+	return err
+}
+
+func (c *Cache) Close() error {
+	var errs []error
+	if c.redis != nil {
+		if err := c.redis.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close redis client: %w", err))
+		}
+	}
+	if err := c.Store.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Cache) getRemote(ctx context.Context, shortURL string) (cachedURL, bool) {
+	if c.redis == nil {
+		return cachedURL{}, false
+	}
+
+	data, err := c.redis.Get(ctx, redisKeyPrefix+shortURL).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return cachedURL{}, false
+	}
+	if err != nil {
+		c.logger.Sugar().Warnf("failed to get %s from redis: %v", shortURL, err)
+		return cachedURL{}, false
+	}
+
+	var value cachedURL
+	if err := json.Unmarshal(data, &value); err != nil {
+		c.logger.Sugar().Warnf("failed to decode %s from redis: %v", shortURL, err)
+		return cachedURL{}, false
+	}
+	return value, true
+}
+
+func (c *Cache) setRemote(ctx context.Context, shortURL string, value cachedURL) {
+	if c.redis == nil {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		c.logger.Sugar().Warnf("failed to encode %s for redis: %v", shortURL, err)
+		return
+	}
+	ttl := c.ttl
+	if until := time.Until(value.ExpiresAt); !value.ExpiresAt.IsZero() && until < ttl {
+		ttl = until
+	}
+	if ttl <= 0 {
+		return
+	}
+	if err := c.redis.Set(ctx, redisKeyPrefix+shortURL, data, ttl).Err(); err != nil {
+		c.logger.Sugar().Warnf("failed to set %s in redis: %v", shortURL, err)
+	}
+}
+
+func (c *Cache) invalidate(ctx context.Context, shortURLs ...string) {
+	if c.local != nil {
+		c.local.Delete(shortURLs...)
+	}
+	if c.redis == nil || len(shortURLs) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		keys = append(keys, redisKeyPrefix+shortURL)
+	}
+	if err := c.redis.Del(ctx, keys...).Err(); err != nil {
+		c.logger.Sugar().Errorf("failed to invalidate %d urls in redis: %v", len(keys), err)
+	}
+}
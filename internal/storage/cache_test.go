@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	"go.uber.org/zap"
+)
+
+type countingStore struct {
+	Store
+	gets int
+}
+
+func (s *countingStore) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
+	s.gets++
+	return s.Store.GetFullURL(ctx, shortURL)
+}
+
+func TestCacheLocal(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{Store: NewMemory()}
+	require.NoError(t, store.SaveURL(ctx, "first", "http://first.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "second", "http://second.ru", "user", models.URLMeta{}))
+
+	cache, err := NewCache(store, 1, time.Minute, "", zap.NewNop())
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		fullURL, deleted, err := cache.GetFullURL(ctx, "first")
+		require.NoError(t, err)
+		assert.Equal(t, "http://first.ru", fullURL)
+		assert.False(t, deleted)
+	}
+	assert.Equal(t, 1, store.gets)
+
+	_, _, err = cache.GetFullURL(ctx, "second")
+	require.NoError(t, err)
+	_, _, err = cache.GetFullURL(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, 3, store.gets, "first is evicted by second")
+
+	require.NoError(t, cache.SetDeletedFlag(ctx, "user", []string{"first"}))
+	_, deleted, err := cache.GetFullURL(ctx, "first")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	assert.Equal(t, 4, store.gets)
+
+	_, _, err = cache.GetFullURL(ctx, "unknown")
+	assert.Error(t, err, "negativ test: errors are not cached")
+	_, _, err = cache.GetFullURL(ctx, "unknown")
+	assert.Error(t, err)
+	assert.Equal(t, 6, store.gets)
+}
+
+func TestCacheLocalTTL(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{Store: NewMemory()}
+	require.NoError(t, store.SaveURL(ctx, "first", "http://first.ru", "user", models.URLMeta{}))
+
+	cache, err := NewCache(store, 10, time.Millisecond, "", zap.NewNop())
+	require.NoError(t, err)
+
+	_, _, err = cache.GetFullURL(ctx, "first")
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, _, err = cache.GetFullURL(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, 2, store.gets)
+}
+
+func TestCacheExpiration(t *testing.T) {
+	const lifetime = 50 * time.Millisecond
+
+	tests := []struct {
+		name  string
+		size  int
+		redis bool
+	}{
+		{name: "positive test: local", size: 10},
+		{name: "positive test: redis", redis: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &countingStore{Store: NewMemory()}
+			require.NoError(t, store.SaveURL(ctx, "soon", "http://soon.ru", "user", models.URLMeta{}))
+
+			var redisURL string
+			var server *miniredis.Miniredis
+			if tt.redis {
+				server = miniredis.RunT(t)
+				redisURL = "redis://" + server.Addr()
+			}
+			cache, err := NewCache(store, tt.size, time.Minute, redisURL, zap.NewNop())
+			require.NoError(t, err)
+			defer func() { require.NoError(t, cache.Close()) }()
+
+			require.NoError(t, cache.SetExpiration(ctx, "soon", time.Now().Add(lifetime)))
+			_, _, err = cache.GetFullURL(ctx, "soon")
+			require.NoError(t, err)
+			if tt.redis {
+				assert.LessOrEqual(t, server.TTL(redisKeyPrefix+"soon"), lifetime, "redis entry lives no longer than the url")
+			}
+
+			time.Sleep(2 * lifetime)
+			for i := 0; i < 2; i++ {
+				_, _, err = cache.GetFullURL(ctx, "soon")
+				assert.ErrorIs(t, err, myErrors.ErrURLExpired, "negativ test: expired url is not served from cache")
+			}
+		})
+	}
+}
+
+func TestCacheRedis(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	redisURL := "redis://" + server.Addr()
+
+	store := &countingStore{Store: NewMemory()}
+	require.NoError(t, store.SaveURL(ctx, "first", "http://first.ru", "user", models.URLMeta{}))
+
+	first, err := NewCache(store, 0, time.Minute, redisURL, zap.NewNop())
+	require.NoError(t, err)
+	second, err := NewCache(store, 0, time.Minute, redisURL, zap.NewNop())
+	require.NoError(t, err)
+
+	_, _, err = first.GetFullURL(ctx, "first")
+	require.NoError(t, err)
+	fullURL, _, err := second.GetFullURL(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, "http://first.ru", fullURL)
+	assert.Equal(t, 1, store.gets, "second instance is served by redis")
+	assert.True(t, server.Exists(redisKeyPrefix+"first"))
+
+	require.NoError(t, first.SetDeletedFlag(ctx, "user", []string{"first"}))
+	assert.False(t, server.Exists(redisKeyPrefix+"first"))
+	_, deleted, err := second.GetFullURL(ctx, "first")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	assert.Equal(t, 2, store.gets)
+
+	server.FastForward(2 * time.Minute)
+	assert.False(t, server.Exists(redisKeyPrefix+"first"))
+
+	server.Close()
+	_, deleted, err = second.GetFullURL(ctx, "first")
+	require.NoError(t, err, "negativ test: redis is down, store is used")
+	assert.True(t, deleted)
+	assert.Equal(t, 3, store.gets)
+
+	require.NoError(t, first.Close())
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"embed"
 	"errors"
-
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
@@ -15,10 +16,18 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
 	"go.uber.org/zap"
 )
 
-const insertSchemaURLs = `INSERT INTO urls (short_url, full_url, user_id, deleted_flag) VALUES ($1, $2, $3, $4)`
+const (
+	insertSchemaURLs = `INSERT INTO urls (short_url, full_url, user_id, deleted_flag, expires_at, title)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	insertSchemaTags = `INSERT INTO url_tags (short_url, tag) SELECT $1, unnest($2::TEXT[]);`
+	shortURLKey      = "urls_pkey"
+	// dbExportBatch is the number of urls read by a query of export.
+	dbExportBatch = 500
+)
 
 type DB struct {
 	pool   *pgxpool.Pool
@@ -49,6 +58,11 @@ func NewDB(ctx context.Context, dsn string, logger *zap.Logger) (Store, error) {
 	return dataBase, nil
 }
 
+// Pool returns the connection pool for monitoring.
+func (db *DB) Pool() *pgxpool.Pool {
+	return db.pool
+}
+
 //go:embed migrations/*.sql
 var migrationsDir embed.FS
 
@@ -77,33 +91,53 @@ func (db *DB) GetPing(ctx context.Context) error {
 	return nil
 }
 
-func (db *DB) SaveURL(ctx context.Context, shortURL string, fullURL string, userID string) error {
-	var shortURLFromDB string
+// SaveURL saves a url, and its tags in the same transaction if it has any.
+func (db *DB) SaveURL(ctx context.Context, shortURL string, fullURL string, userID string, meta models.URLMeta) error {
+	if len(meta.Tags) != 0 {
+		return db.SaveURLBatch(ctx, map[string]string{shortURL: fullURL}, userID,
+			map[string]models.URLMeta{shortURL: meta})
+	}
 
-	err := db.pool.QueryRow(ctx, insertSchemaURLs, []byte(shortURL), fullURL, userID, false).Scan(&shortURLFromDB)
+	_, err := db.pool.Exec(ctx, insertSchemaURLs,
+		shortURL, fullURL, userID, false, optionalTime(meta.ExpiresAt), meta.Title)
+	return saveURLError(shortURL, err)
+}
 
-	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			return myErrors.ErrURLAlreadySaved
-		}
+// saveURLError maps unique violations to ErrKeyAlreadyExists for the short
+// URL key and ErrURLAlreadySaved for the full URL.
+func saveURLError(shortURL string, err error) error {
+	if err == nil {
+		return nil
 	}
 
-	return nil
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		if pgErr.ConstraintName == shortURLKey {
+			return fmt.Errorf("failed to save shortURL %s: %w", shortURL, myErrors.ErrKeyAlreadyExists)
+		}
+		return myErrors.ErrURLAlreadySaved
+	}
+	return fmt.Errorf("failed to save shortURL %s: %w", shortURL, err)
 }
 
 func (db *DB) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
-	const selectSchemaFullURL = `SELECT full_url, deleted_flag FROM urls WHERE short_url = $1;`
+	const selectSchemaFullURL = `SELECT full_url, deleted_flag, expires_at FROM urls WHERE short_url = $1;`
 
 	var (
 		fullURL     string
 		deletedFlag bool
+		expiresAt   *time.Time
 	)
 
-	if err := db.pool.QueryRow(ctx, selectSchemaFullURL, shortURL).Scan(&fullURL, &deletedFlag); err != nil {
+	row := db.pool.QueryRow(ctx, selectSchemaFullURL, shortURL)
+	if err := row.Scan(&fullURL, &deletedFlag, &expiresAt); err != nil {
 		return "", false, fmt.Errorf("failed to find shortURL=%s in database: %w", shortURL, err)
 	}
 
+	if expiresAt != nil && !time.Now().Before(*expiresAt) {
+		return "", false, fmt.Errorf("shortURL=%s: %w", shortURL, myErrors.ErrURLExpired)
+	}
+
 	return fullURL, deletedFlag, nil
 }
 
@@ -120,33 +154,46 @@ func (db *DB) GetShortURL(ctx context.Context, fullURL string) string {
 	return shortURL
 }
 
-func (db *DB) SaveURLBatch(ctx context.Context, urls map[string]string, userID string) error {
+func (db *DB) SaveURLBatch(
+	ctx context.Context,
+	urls map[string]string,
+	userID string,
+	meta map[string]models.URLMeta,
+) error {
 	tx, err := db.pool.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
 	defer func() {
-		if err := tx.Rollback(ctx); err != nil {
-			db.logger.Sugar().Infof("failed to rollback: %w", err)
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			db.logger.Sugar().Infof("failed to rollback: %v", err)
 		}
 	}()
 
+	// shortURLs holds the short URL of every queued query.
 	batch := &pgx.Batch{}
-
+	shortURLs := make([]string, 0, len(urls))
 	for k, v := range urls {
-		batch.Queue(insertSchemaURLs, []byte(k), v, userID, false)
+		batch.Queue(insertSchemaURLs, k, v, userID, false, optionalTime(meta[k].ExpiresAt), meta[k].Title)
+		shortURLs = append(shortURLs, k)
+		if tags := meta[k].Tags; len(tags) != 0 {
+			batch.Queue(insertSchemaTags, k, tags)
+			shortURLs = append(shortURLs, k)
+		}
 	}
 
-	results := db.pool.SendBatch(ctx, batch)
-	defer func() {
-		if err := results.Close(); err != nil {
-			db.logger.Sugar().Infof("failed to close batch results: %w", err)
+	results := tx.SendBatch(ctx, batch)
+	for _, shortURL := range shortURLs {
+		if _, err := results.Exec(); err != nil {
+			if err := results.Close(); err != nil {
+				db.logger.Sugar().Infof("failed to close batch results: %v", err)
+			}
+			return saveURLError(shortURL, err)
 		}
-	}()
-
-	if results == nil {
-		return fmt.Errorf("failed to send batch")
+	}
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("failed to close batch results: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -156,40 +203,310 @@ func (db *DB) SaveURLBatch(ctx context.Context, urls map[string]string, userID s
 	return nil
 }
 
-func (db *DB) GetURLByUserID(ctx context.Context, userID string) map[string]string {
-	const selectSchemaURLsByUserID = `SELECT short_url, full_url FROM urls WHERE user_id = $1;`
+// GetURLByUserID selects the urls of query.UserID matching its filters. The
+// optional conditions are switched off by NULL arguments, so one prepared
+// statement serves every query.
+func (db *DB) GetURLByUserID(ctx context.Context, query models.URLQuery) ([]models.UsersURLs, error) {
+	const selectSchemaURLsByUserID = `SELECT short_url, full_url, created_at, updated_at, title,
+		COALESCE(deleted_flag, FALSE),
+		ARRAY(SELECT tag FROM url_tags t WHERE t.short_url = urls.short_url ORDER BY tag)
+		FROM urls WHERE user_id = $1
+		AND ($2::TEXT IS NULL OR short_url = $2)
+		AND ($3::BOOLEAN IS NULL OR COALESCE(deleted_flag, FALSE) = $3)
+		AND ($4::TEXT IS NULL OR strpos(
+			substring(lower(full_url) from '^[a-z][a-z0-9+.-]*://(?:[^/?#@]*@)?([^/:?#]+)'), $4) > 0)
+		AND ($9::TEXT IS NULL OR EXISTS (SELECT 1 FROM url_tags t WHERE t.short_url = urls.short_url
+			AND (t.tag = $9 OR starts_with(t.tag, $9 || '/'))))
+		AND ($5::TIMESTAMPTZ IS NULL OR
+			($7::BOOLEAN AND (created_at, short_url) < ($5, $6::TEXT)) OR
+			(NOT $7 AND (created_at, short_url) > ($5, $6::TEXT)))
+		ORDER BY
+			CASE WHEN $7 THEN created_at END DESC, CASE WHEN $7 THEN short_url END DESC,
+			created_at, short_url
+		LIMIT $8::INTEGER;`
+
+	var shortURL, domain, afterShortURL, tag *string
+	var afterCreatedAt *time.Time
+	if query.ShortURL != "" {
+		shortURL = &query.ShortURL
+	}
+	if query.Domain != "" {
+		lower := strings.ToLower(query.Domain)
+		domain = &lower
+	}
+	if query.Tag != "" {
+		tag = &query.Tag
+	}
+	if query.After != nil {
+		afterCreatedAt, afterShortURL = &query.After.CreatedAt, &query.After.ShortURL
+	}
+	var limit *int
+	if query.Limit > 0 {
+		limit = &query.Limit
+	}
 
-	rows, err := db.pool.Query(ctx, selectSchemaURLsByUserID, userID)
+	rows, err := db.pool.Query(ctx, selectSchemaURLsByUserID, query.UserID, shortURL, query.Deleted, domain,
+		afterCreatedAt, afterShortURL, query.Desc, limit, tag)
 	if err != nil {
-		db.logger.Sugar().Errorf("failed to select by user_id: %w", err)
-		return nil
+		return nil, fmt.Errorf("failed to select urls of user_id=%s: %w", query.UserID, err)
 	}
-
 	defer rows.Close()
 
-	urls := make(map[string]string)
+	urls := make([]models.UsersURLs, 0)
 	for rows.Next() {
-		var shortURL, fullURL string
-		err := rows.Scan(&shortURL, &fullURL)
+		var url models.UsersURLs
+		if err := rows.Scan(&url.ShortURL, &url.OriginalURL, &url.CreatedAt, &url.UpdatedAt, &url.Title,
+			&url.Deleted, &url.Tags); err != nil {
+			return nil, fmt.Errorf("failed to scan url of user_id=%s: %w", query.UserID, err)
+		}
+		urls = append(urls, url)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read urls of user_id=%s: %w", query.UserID, err)
+	}
+	return urls, nil
+}
+
+// ExportURLs passes the urls of userID to yield in batches read by short
+// URL, so the whole list is never kept in memory and no query stays open
+// while yield writes them out.
+func (db *DB) ExportURLs(ctx context.Context, userID string, yield func(models.ExportedURL) error) error {
+	after := ""
+	for {
+		batch, err := db.exportBatch(ctx, userID, after)
 		if err != nil {
-			db.logger.Sugar().Errorf("failed to get rows from select by user_id: %w", err)
+			return err
+		}
+
+		for _, url := range batch {
+			if err := yield(url); err != nil {
+				return err
+			}
+		}
+		if len(batch) < dbExportBatch {
+			return nil
+		}
+		after = batch[len(batch)-1].ShortURL
+	}
+}
+
+func (db *DB) exportBatch(ctx context.Context, userID string, after string) ([]models.ExportedURL, error) {
+	const selectSchemaExport = `SELECT u.short_url, u.full_url, u.deleted_flag, u.created_at,
+		(SELECT COUNT(*) FROM clicks c WHERE c.short_url = u.short_url)
+		FROM urls u WHERE u.user_id = $1 AND u.short_url > $2
+		ORDER BY u.short_url LIMIT $3;`
+
+	rows, err := db.pool.Query(ctx, selectSchemaExport, userID, after, dbExportBatch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select urls of user_id=%s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	batch := make([]models.ExportedURL, 0, dbExportBatch)
+	for rows.Next() {
+		var url models.ExportedURL
+		var clicks int64
+		var createdAt time.Time
+		if err := rows.Scan(&url.ShortURL, &url.OriginalURL, &url.Deleted, &createdAt, &clicks); err != nil {
+			return nil, fmt.Errorf("failed to scan url of user_id=%s: %w", userID, err)
+		}
+		url.Clicks, url.CreatedAt = &clicks, &createdAt
+		batch = append(batch, url)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read urls of user_id=%s: %w", userID, err)
+	}
+	return batch, nil
+}
+
+func (db *DB) SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) error {
+	const updateSchemaDeletedFlag = `UPDATE urls SET deleted_flag = TRUE, updated_at = NOW()
+		WHERE short_url = ANY($1) AND user_id = $2;`
+
+	_, err := db.pool.Exec(ctx, updateSchemaDeletedFlag, shortURLs, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update deleted flag for %d urls: %w", len(shortURLs), err)
+	}
+	return nil
+}
+
+// GetExpiration returns the expiration time of a url, zero when it never
+// expires.
+func (db *DB) GetExpiration(ctx context.Context, shortURL string) (time.Time, error) {
+	const selectSchemaExpiresAt = `SELECT expires_at FROM urls WHERE short_url = $1;`
+
+	var expiresAt *time.Time
+	err := db.pool.QueryRow(ctx, selectSchemaExpiresAt, shortURL).Scan(&expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("URL `%s`: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to select expiration for short_url=%s: %w", shortURL, err)
+	}
+	if expiresAt == nil {
+		return time.Time{}, nil
+	}
+	return *expiresAt, nil
+}
+
+func (db *DB) SetExpiration(ctx context.Context, shortURL string, expiresAt time.Time) error {
+	const updateSchemaExpiresAt = `UPDATE urls SET expires_at = $1, updated_at = NOW() WHERE short_url = $2;`
+
+	tag, err := db.pool.Exec(ctx, updateSchemaExpiresAt, expiresAt, shortURL)
+	if err != nil {
+		return fmt.Errorf("failed to update expiration for short_url=%s: %w", shortURL, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set expiration for short_url=%s", shortURL)
+	}
+	return nil
+}
+
+func (db *DB) SetTitle(ctx context.Context, shortURL string, title string) error {
+	const updateSchemaTitle = `UPDATE urls SET title = $1, updated_at = NOW() WHERE short_url = $2;`
+
+	tag, err := db.pool.Exec(ctx, updateSchemaTitle, title, shortURL)
+	if err != nil {
+		return fmt.Errorf("failed to update title for short_url=%s: %w", shortURL, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set title for short_url=%s: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+	return nil
+}
+
+// SetTags replaces the tags of a url of userID in one transaction.
+func (db *DB) SetTags(ctx context.Context, userID string, shortURL string, tags []string) error {
+	const (
+		updateSchemaTagsOwner = `UPDATE urls SET updated_at = NOW() WHERE short_url = $1 AND user_id = $2;`
+		deleteSchemaTags      = `DELETE FROM url_tags WHERE short_url = $1;`
+	)
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			db.logger.Sugar().Infof("failed to rollback: %v", err)
 		}
-		urls[shortURL] = fullURL
+	}()
+
+	tag, err := tx.Exec(ctx, updateSchemaTagsOwner, shortURL, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update short_url=%s: %w", shortURL, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set tags for short_url=%s: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+
+	if _, err := tx.Exec(ctx, deleteSchemaTags, shortURL); err != nil {
+		return fmt.Errorf("failed to delete tags of short_url=%s: %w", shortURL, err)
+	}
+	if _, err := tx.Exec(ctx, insertSchemaTags, shortURL, tags); err != nil {
+		return fmt.Errorf("failed to insert tags of short_url=%s: %w", shortURL, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) FlagExpired(ctx context.Context) (int64, error) {
+	const updateSchemaExpired = `UPDATE urls SET deleted_flag = TRUE, updated_at = NOW()
+		WHERE expires_at <= NOW() AND deleted_flag IS NOT TRUE;`
+
+	tag, err := db.pool.Exec(ctx, updateSchemaExpired)
+	if err != nil {
+		return 0, fmt.Errorf("failed to flag expired urls: %w", err)
 	}
+	return tag.RowsAffected(), nil
+}
+
+// LeaseIDs reserves count values of the short URL counter, atomically for all
+// the instances using the DB.
+func (db *DB) LeaseIDs(ctx context.Context, count uint64) (uint64, error) {
+	const upsertSchemaLease = `INSERT INTO id_leases (name, next) VALUES ('short_url', $1 + $2)
+		ON CONFLICT (name) DO UPDATE SET next = id_leases.next + $2
+		RETURNING next - $2;`
 
-	return urls
+	var start int64
+	err := db.pool.QueryRow(ctx, upsertSchemaLease, firstID(), int64(count)).Scan(&start)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lease %d ids: %w", count, err)
+	}
+	return uint64(start), nil
 }
 
-func (db *DB) SetDeletedFlag(ctx context.Context, userID string, shortURL string) error {
-	const updateSchemaDeletedFlag = `UPDATE urls SET deleted_flag = $1 WHERE short_url = $2 AND user_id = $3;`
+func (db *DB) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	rows := make([][]any, 0, len(clicks))
+	for _, click := range clicks {
+		rows = append(rows, []any{click.ShortURL, click.Time, click.Referer, click.UserAgent, click.IPHash})
+	}
 
-	_, err := db.pool.Exec(ctx, updateSchemaDeletedFlag, true, shortURL, userID)
+	_, err := db.pool.CopyFrom(ctx,
+		pgx.Identifier{"clicks"},
+		[]string{"short_url", "clicked_at", "referer", "user_agent", "ip_hash"},
+		pgx.CopyFromRows(rows),
+	)
 	if err != nil {
-		return fmt.Errorf("failed to update deleted flag for short_url=%s: %w", shortURL, err)
+		return fmt.Errorf("failed to copy %d clicks: %w", len(clicks), err)
 	}
 	return nil
 }
 
+func (db *DB) GetClickStats(ctx context.Context, shortURL string) (models.URLStats, error) {
+	const selectSchemaClicksByDay = `SELECT to_char(date_trunc('day', clicked_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD'),
+		COUNT(*) FROM clicks WHERE short_url = $1 GROUP BY 1 ORDER BY 1;`
+
+	stats := models.URLStats{ShortURL: shortURL, Days: make([]models.DayClicks, 0)}
+
+	rows, err := db.pool.Query(ctx, selectSchemaClicksByDay, shortURL)
+	if err != nil {
+		return stats, fmt.Errorf("failed to select clicks for short_url=%s: %w", shortURL, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day models.DayClicks
+		if err := rows.Scan(&day.Day, &day.Clicks); err != nil {
+			return stats, fmt.Errorf("failed to scan clicks for short_url=%s: %w", shortURL, err)
+		}
+		stats.Days = append(stats.Days, day)
+		stats.Total += day.Clicks
+	}
+
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("failed to read clicks for short_url=%s: %w", shortURL, err)
+	}
+
+	return stats, nil
+}
+
+func (db *DB) GetURLsCount(ctx context.Context) (int, error) {
+	const selectSchemaURLsCount = `SELECT COUNT(*) FROM urls;`
+
+	var count int
+	if err := db.pool.QueryRow(ctx, selectSchemaURLsCount).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count urls: %w", err)
+	}
+	return count, nil
+}
+
+func (db *DB) GetUsersCount(ctx context.Context) (int, error) {
+	const selectSchemaUsersCount = `SELECT COUNT(DISTINCT user_id) FROM urls WHERE user_id <> '';`
+
+	var count int
+	if err := db.pool.QueryRow(ctx, selectSchemaUsersCount).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
 func (db *DB) Close() error {
 	db.pool.Close()
 	return nil
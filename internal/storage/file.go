@@ -2,78 +2,673 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 
 	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
 	"go.uber.org/zap"
 )
 
+// FileSync tells when File flushes its log to disk.
+type FileSync string
+
+const (
+	// FileSyncAlways fsyncs every write before it is acknowledged.
+	FileSyncAlways FileSync = "always"
+	// FileSyncInterval fsyncs once a second, a crash loses up to a second of
+	// acknowledged writes.
+	FileSyncInterval FileSync = "interval"
+	// FileSyncNever leaves flushing to the OS.
+	FileSyncNever FileSync = "never"
+)
+
+type FileOptions struct {
+	Sync FileSync
+	// CompactInterval is how often the log is checked for compaction, zero
+	// disables compaction.
+	CompactInterval time.Duration
+}
+
+const (
+	clicksFileSuffix  = ".clicks"
+	compactFileSuffix = ".compact"
+	filePerm          = 0666
+	fileSyncInterval  = time.Second
+	// minCompactEvents keeps small logs from being rewritten over and over.
+	minCompactEvents = 1000
+	checksumLength   = 8
+)
+
+// Types of the log events.
+const (
+	eventCreate  = "create"
+	eventDelete  = "delete"
+	eventExpired = "expired"
+	eventExpire  = "expire"
+	eventTitle   = "title"
+	eventTags    = "tags"
+	eventLease   = "lease"
+)
+
+// URLsJSON is a line of the file written before the log of events. Such
+// lines are still read and turn into create events.
 type URLsJSON struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	UUID        string     `json:"uuid"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	Title       string     `json:"title,omitempty"`
 }
 
-type File struct {
-	memory *Memory
-	file   *os.File
-	logger *zap.Logger
+// fileURL is the whole state of a url in a create event.
+type fileURL struct {
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	Title       string     `json:"title,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+}
+
+// fileClick is a line of the clicks file. It is either a click or, once the
+// file is compacted, the number of clicks of a short URL in a day.
+type fileClick struct {
+	models.Click
+	Day    string `json:"day,omitempty"`
+	Clicks int64  `json:"clicks,omitempty"`
+}
+
+// fileDayClicks is the line that compaction writes for a short URL and day.
+type fileDayClicks struct {
+	ShortURL string `json:"short_url"`
+	Day      string `json:"day"`
+	Clicks   int64  `json:"clicks"`
 }
 
-func NewFile(filePath string, logger *zap.Logger) (Store, error) {
-	memory := &Memory{urls: make(map[string]URLInfo)}
-	const perm = 0666
+// fileEvent is a record of the log. The fields set depend on Type.
+type fileEvent struct {
+	At        time.Time  `json:"at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	URLs      []fileURL  `json:"urls,omitempty"`
+	ShortURLs []string   `json:"short_urls,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Type      string     `json:"type"`
+	UserID    string     `json:"user_id,omitempty"`
+	ShortURL  string     `json:"short_url,omitempty"`
+	Title     string     `json:"title,omitempty"`
+	NextID    uint64     `json:"next_id,omitempty"`
+}
+
+// File keeps urls in Memory and persists them as an append-only log of
+// events. Every record is a line of the CRC-32 of its JSON in hex, a space
+// and the JSON. A change is validated, written to the log and only then
+// applied to Memory, and loading replays the log through the same apply.
+// Corrupt records are skipped and a torn last record is cut off. Once the log
+// holds more than twice as many events as there are urls, it is replaced by a
+// snapshot of create events written to a temporary file and renamed over it.
+// The clicks are kept in a file of their own and compacted the same way into
+// the numbers of clicks per short URL and day.
+type File struct {
+	memory     *Memory
+	file       *os.File
+	clicksFile *os.File
+	logger     *zap.Logger
+	stop       chan struct{}
+	done       chan struct{}
+	path       string
+	options    FileOptions
+	mu         sync.Mutex
+	size       int64
+	events     int
+	clickLines int
+	dirty      bool
+}
 
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, perm)
+func NewFile(filePath string, options FileOptions, logger *zap.Logger) (Store, error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, filePerm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %s, %w", filePath, err)
 	}
-	f := &File{memory: memory, file: file, logger: logger}
+
+	clicksFile, err := os.OpenFile(filePath+clicksFileSuffix, os.O_CREATE|os.O_RDWR|os.O_APPEND, filePerm)
+	if err != nil {
+		return nil, errors.Join(
+			fmt.Errorf("failed to open file: %s, %w", filePath+clicksFileSuffix, err),
+			file.Close(),
+		)
+	}
+
+	f := &File{
+		memory:     newMemory(),
+		file:       file,
+		clicksFile: clicksFile,
+		logger:     logger,
+		path:       filePath,
+		options:    options,
+	}
 	if err := f.loadURLs(); err != nil {
-		logger.Sugar().Info("failed to get data from temp file", err)
+		return nil, errors.Join(err, file.Close(), clicksFile.Close())
+	}
+	if err := f.loadClicks(); err != nil {
+		return nil, errors.Join(err, file.Close(), clicksFile.Close())
+	}
+
+	if options.Sync == FileSyncInterval || options.CompactInterval > 0 {
+		f.stop, f.done = make(chan struct{}), make(chan struct{})
+		go f.run()
 	}
 	return f, nil
 }
 
+// readRecords passes every complete line of file to record and returns the
+// offset where the complete lines end.
+func readRecords(file *os.File, record func(line []byte, offset int64)) (int64, error) {
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, fmt.Errorf("failed to read %s: %w", file.Name(), err)
+		}
+		record(line[:len(line)-1], offset)
+		offset += int64(len(line))
+	}
+}
+
+// cutTornTail removes the part of a record left by an interrupted write, so
+// that the next record starts on its own line.
+func (f *File) cutTornTail(file *os.File, end int64) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", file.Name(), err)
+	}
+	if info.Size() == end {
+		return end, nil
+	}
+
+	f.logger.Sugar().Warnf("cutting torn record of %d bytes at the end of %s", info.Size()-end, file.Name())
+	if err := file.Truncate(end); err != nil {
+		return 0, fmt.Errorf("failed to truncate %s: %w", file.Name(), err)
+	}
+	return end, nil
+}
+
 func (f *File) loadURLs() error {
-	scanner := bufio.NewScanner(f.file)
+	legacy := make(map[string]URLsJSON)
+	end, err := readRecords(f.file, func(line []byte, offset int64) {
+		if bytes.HasPrefix(line, []byte("{")) {
+			var urlsJSON URLsJSON
+			if err := json.Unmarshal(line, &urlsJSON); err != nil {
+				f.logger.Sugar().Warnf("skipping corrupt record at offset %d of %s: %v", offset, f.path, err)
+				return
+			}
+			legacy[urlsJSON.ShortURL] = urlsJSON
+			return
+		}
 
-	urls := make(map[string]URLInfo)
-	for scanner.Scan() {
-		urlsJSON := URLsJSON{}
-		err := json.Unmarshal(scanner.Bytes(), &urlsJSON)
+		event, err := decodeEvent(line)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshall temp file %w", err)
+			f.logger.Sugar().Warnf("skipping corrupt record at offset %d of %s: %v", offset, f.path, err)
+			return
 		}
-		urls[urlsJSON.ShortURL] = URLInfo{fullURL: urlsJSON.OriginalURL}
+		f.applyLegacy(legacy)
+		f.apply(event)
+		f.events++
+	})
+	if err != nil {
+		return err
 	}
-	for k, v := range urls {
-		if err := f.memory.SaveURL(context.Background(), k, v.fullURL, v.userID); err != nil {
-			return fmt.Errorf("failed to save in local memory %w", err)
+	f.applyLegacy(legacy)
+
+	f.size, err = f.cutTornTail(f.file, end)
+	return err
+}
+
+// applyLegacy restores the urls read from lines of the old format, which
+// repeat a url on every change, so only the last line of a url is kept.
+func (f *File) applyLegacy(legacy map[string]URLsJSON) {
+	now := time.Now()
+	for shortURL, urlsJSON := range legacy {
+		url := fileURL{
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			ExpiresAt:   urlsJSON.ExpiresAt,
+			Tags:        urlsJSON.Tags,
+			ShortURL:    shortURL,
+			OriginalURL: urlsJSON.OriginalURL,
+			Title:       urlsJSON.Title,
+		}
+		if urlsJSON.CreatedAt != nil {
+			url.CreatedAt = *urlsJSON.CreatedAt
+		}
+		if urlsJSON.UpdatedAt != nil {
+			url.UpdatedAt = *urlsJSON.UpdatedAt
+		}
+		f.apply(fileEvent{Type: eventCreate, At: now, URLs: []fileURL{url}})
+		f.events++
+		delete(legacy, shortURL)
+	}
+}
+
+func (f *File) loadClicks() error {
+	end, err := readRecords(f.clicksFile, func(line []byte, offset int64) {
+		click := fileClick{}
+		if err := json.Unmarshal(line, &click); err != nil {
+			f.logger.Sugar().Warnf("skipping corrupt click at offset %d of %s: %v", offset, f.clicksFile.Name(), err)
+			return
+		}
+		if click.Day != "" {
+			f.memory.addClicks(click.ShortURL, click.Day, click.Clicks)
+		} else {
+			f.memory.addClicks(click.ShortURL, click.Time.UTC().Format(clickDayLayout), 1)
+		}
+		f.clickLines++
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = f.cutTornTail(f.clicksFile, end)
+	return err
+}
+
+func encodeEvent(event fileEvent) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s event: %w", event.Type, err)
+	}
+
+	record := make([]byte, 0, checksumLength+1+len(data)+1)
+	record = fmt.Appendf(record, "%08x ", crc32.ChecksumIEEE(data))
+	record = append(record, data...)
+	return append(record, '\n'), nil
+}
+
+func decodeEvent(line []byte) (fileEvent, error) {
+	var event fileEvent
+	if len(line) < checksumLength+1 || line[checksumLength] != ' ' {
+		return event, errors.New("record has no checksum")
+	}
+
+	checksum, err := strconv.ParseUint(string(line[:checksumLength]), 16, 32)
+	if err != nil {
+		return event, fmt.Errorf("failed to parse checksum: %w", err)
+	}
+	data := line[checksumLength+1:]
+	if uint32(checksum) != crc32.ChecksumIEEE(data) {
+		return event, errors.New("checksum mismatch")
+	}
+
+	if err := json.Unmarshal(data, &event); err != nil {
+		return event, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+	return event, nil
+}
+
+// apply changes Memory as event tells.
+func (f *File) apply(event fileEvent) {
+	change := func(shortURL string, fn func(url *URLInfo) bool) {
+		f.memory.update(shortURL, func(url *URLInfo) bool {
+			if !fn(url) {
+				return false
+			}
+			url.updatedAt = event.At
+			return true
+		})
+	}
+
+	switch event.Type {
+	case eventCreate:
+		for _, u := range event.URLs {
+			url := URLInfo{
+				createdAt:   u.CreatedAt,
+				updatedAt:   u.UpdatedAt,
+				tags:        u.Tags,
+				fullURL:     u.OriginalURL,
+				userID:      event.UserID,
+				title:       u.Title,
+				DeletedFlag: u.Deleted,
+			}
+			if u.ExpiresAt != nil {
+				url.expiresAt = *u.ExpiresAt
+			}
+			if !f.memory.restore(u.ShortURL, url) {
+				f.logger.Sugar().Warnf("skipping already saved url %s", u.ShortURL)
+			}
+		}
+	case eventDelete, eventExpired:
+		for _, shortURL := range event.ShortURLs {
+			change(shortURL, func(url *URLInfo) bool {
+				if event.Type == eventDelete && url.userID != event.UserID {
+					return false
+				}
+				url.DeletedFlag = true
+				return true
+			})
+		}
+	case eventExpire:
+		change(event.ShortURL, func(url *URLInfo) bool {
+			url.expiresAt = time.Time{}
+			if event.ExpiresAt != nil {
+				url.expiresAt = *event.ExpiresAt
+			}
+			return true
+		})
+	case eventTitle:
+		change(event.ShortURL, func(url *URLInfo) bool {
+			url.title = event.Title
+			return true
+		})
+	case eventTags:
+		change(event.ShortURL, func(url *URLInfo) bool {
+			url.tags = event.Tags
+			return true
+		})
+	case eventLease:
+		f.memory.restoreNextID(event.NextID)
+	default:
+		f.logger.Sugar().Warnf("skipping event of unknown type %q", event.Type)
+	}
+}
+
+// write appends event to the log and applies it. The caller holds f.mu.
+func (f *File) write(event fileEvent) error {
+	record, err := encodeEvent(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.file.Write(record); err != nil {
+		return errors.Join(
+			fmt.Errorf("failed to write %s event: %w", event.Type, err),
+			f.file.Truncate(f.size),
+		)
+	}
+	if err := f.flush(f.file, f.path); err != nil {
+		return errors.Join(err, f.file.Truncate(f.size))
+	}
+	f.size += int64(len(record))
+	f.events++
+
+	f.apply(event)
+	return nil
+}
+
+// flush fsyncs file at path when the sync policy asks for it after every
+// write.
+func (f *File) flush(file *os.File, path string) error {
+	if f.options.Sync != FileSyncAlways {
+		f.dirty = true
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return nil
+}
+
+func (f *File) run() {
+	defer close(f.done)
+
+	var syncTick, compactTick <-chan time.Time
+	if f.options.Sync == FileSyncInterval {
+		ticker := time.NewTicker(fileSyncInterval)
+		defer ticker.Stop()
+		syncTick = ticker.C
+	}
+	if f.options.CompactInterval > 0 {
+		ticker := time.NewTicker(f.options.CompactInterval)
+		defer ticker.Stop()
+		compactTick = ticker.C
+	}
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-syncTick:
+			if err := f.sync(); err != nil {
+				f.logger.Sugar().Errorf("failed to sync file storage: %v", err)
+			}
+		case <-compactTick:
+			if err := f.compactIfNeeded(); err != nil {
+				f.logger.Sugar().Errorf("failed to compact file storage: %v", err)
+			}
 		}
 	}
+}
 
+func (f *File) sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.dirty {
+		return nil
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", f.path, err)
+	}
+	if err := f.clicksFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", f.path+clicksFileSuffix, err)
+	}
+	f.dirty = false
 	return nil
 }
 
-func (f *File) SaveURL(ctx context.Context, shortURL string, fullURL string, userID string) error {
-	if err := f.memory.SaveURL(ctx, shortURL, fullURL, userID); err != nil {
-		if errors.Is(err, myErrors.ErrURLAlreadySaved) {
-			return err
+func (f *File) compactIfNeeded() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count, err := f.memory.GetURLsCount(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to count urls: %w", err)
+	}
+
+	var errs []error
+	if f.events >= minCompactEvents && f.events > 2*count {
+		errs = append(errs, f.compact())
+	}
+	if f.clickLines >= minCompactEvents && f.clickLines > 2*f.memory.clickDays() {
+		errs = append(errs, f.compactClicks())
+	}
+	return errors.Join(errs...)
+}
+
+// compact replaces the log by create events of the current urls and a lease
+// event of the id counter. The caller holds f.mu.
+func (f *File) compact() error {
+	file, size, events, err := f.replaceFile(f.path, f.writeSnapshot)
+	if file == nil {
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		f.logger.Sugar().Warnf("failed to close compacted %s: %v", f.path, err)
+	}
+
+	f.logger.Sugar().Infof("compacted %s from %d to %d events", f.path, f.events, events)
+	f.file, f.size, f.events = file, size, events
+	return err
+}
+
+// compactClicks replaces the clicks by the numbers of clicks per short URL and
+// day. The caller holds f.mu.
+func (f *File) compactClicks() error {
+	path := f.path + clicksFileSuffix
+	file, _, lines, err := f.replaceFile(path, f.writeClicksSnapshot)
+	if file == nil {
+		return err
+	}
+	if err := f.clicksFile.Close(); err != nil {
+		f.logger.Sugar().Warnf("failed to close compacted %s: %v", path, err)
+	}
+
+	f.logger.Sugar().Infof("compacted %s from %d to %d lines", path, f.clickLines, lines)
+	f.clicksFile, f.clickLines = file, lines
+	return err
+}
+
+// replaceFile writes the records of write to a temporary file and renames it
+// over path. The temporary file is opened for appending and returned still
+// open, so it goes on as the file at path without being reopened. It is nil
+// when path is left as it was; otherwise the rename is done and err only
+// tells that it may not be durable yet.
+func (f *File) replaceFile(
+	path string,
+	write func(writer *bufio.Writer) (int, error),
+) (*os.File, int64, int, error) {
+	tmpPath := path + compactFileSuffix
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, filePerm)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	fail := func(err error) (*os.File, int64, int, error) {
+		return nil, 0, 0, errors.Join(err, file.Close(), os.Remove(tmpPath))
+	}
+
+	writer := bufio.NewWriter(file)
+	records, err := write(writer)
+	if err != nil {
+		return fail(fmt.Errorf("failed to write %s: %w", tmpPath, err))
+	}
+	if err := writer.Flush(); err != nil {
+		return fail(fmt.Errorf("failed to flush %s: %w", tmpPath, err))
+	}
+	if err := file.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to sync %s: %w", tmpPath, err))
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return fail(fmt.Errorf("failed to stat %s: %w", tmpPath, err))
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fail(fmt.Errorf("failed to replace %s: %w", path, err))
+	}
+	return file, info.Size(), records, syncDir(filepath.Dir(path))
+}
+
+// writeSnapshot writes a lease event of the id counter and a create event of
+// every url.
+func (f *File) writeSnapshot(writer *bufio.Writer) (int, error) {
+	var events int
+	f.memory.idsMu.Lock()
+	nextID := f.memory.nextID
+	f.memory.idsMu.Unlock()
+	if nextID != 0 {
+		if err := writeEvent(writer, fileEvent{Type: eventLease, At: time.Now(), NextID: nextID}); err != nil {
+			return 0, err
+		}
+		events++
+	}
+
+	var err error
+	f.memory.forEach(func(shortURL string, url URLInfo) {
+		if err != nil {
+			return
 		}
-		return fmt.Errorf("failed to save in local memory %w", err)
+		u := fileURL{
+			CreatedAt:   url.createdAt,
+			UpdatedAt:   url.updatedAt,
+			ExpiresAt:   optionalTime(url.expiresAt),
+			Tags:        url.tags,
+			ShortURL:    shortURL,
+			OriginalURL: url.fullURL,
+			Title:       url.title,
+			Deleted:     url.DeletedFlag,
+		}
+		err = writeEvent(writer, fileEvent{Type: eventCreate, At: url.updatedAt, UserID: url.userID, URLs: []fileURL{u}})
+		events++
+	})
+	return events, err
+}
+
+func writeEvent(writer io.Writer, event fileEvent) error {
+	record, err := encodeEvent(event)
+	if err != nil {
+		return err
 	}
+	_, err = writer.Write(record)
+	return err
+}
 
-	f.writeURLInFile(shortURL, fullURL)
+// writeClicksSnapshot writes a line of the number of clicks of every short URL
+// and day.
+func (f *File) writeClicksSnapshot(writer *bufio.Writer) (int, error) {
+	var lines int
+	var err error
+	f.memory.forEachClickDay(func(shortURL string, day string, clicks int64) {
+		if err != nil {
+			return
+		}
+		var data []byte
+		data, err = json.Marshal(fileDayClicks{ShortURL: shortURL, Day: day, Clicks: clicks})
+		if err == nil {
+			_, err = writer.Write(append(data, '\n'))
+		}
+		lines++
+	})
+	return lines, err
+}
 
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	if err := d.Sync(); err != nil {
+		return errors.Join(fmt.Errorf("failed to sync directory %s: %w", dir, err), d.Close())
+	}
+	if err := d.Close(); err != nil {
+		return fmt.Errorf("failed to close directory %s: %w", dir, err)
+	}
 	return nil
 }
 
+func (f *File) SaveURL(ctx context.Context, shortURL string, fullURL string, userID string, meta models.URLMeta) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.memory.GetShortURL(ctx, fullURL) != "" {
+		return myErrors.ErrURLAlreadySaved
+	}
+	if f.memory.exists(shortURL) {
+		return fmt.Errorf("failed to save shortURL %s: %w", shortURL, myErrors.ErrKeyAlreadyExists)
+	}
+
+	now := time.Now()
+	return f.write(fileEvent{Type: eventCreate, At: now, UserID: userID, URLs: []fileURL{
+		{
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			ExpiresAt:   optionalTime(meta.ExpiresAt),
+			Tags:        meta.Tags,
+			ShortURL:    shortURL,
+			OriginalURL: fullURL,
+			Title:       meta.Title,
+		},
+	}})
+}
+
 func (f *File) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
 	return f.memory.GetFullURL(ctx, shortURL)
 }
@@ -82,63 +677,211 @@ func (f *File) GetShortURL(ctx context.Context, fullURL string) string {
 	return f.memory.GetShortURL(ctx, fullURL)
 }
 
-func (f *File) SaveURLBatch(ctx context.Context, urls map[string]string, userID string) error {
-	if err := f.memory.SaveURLBatch(ctx, urls, userID); err != nil {
-		return fmt.Errorf("failed to save URL slice %w", err)
-	}
+// SaveURLBatch saves all urls or none of them in one event.
+func (f *File) SaveURLBatch(
+	ctx context.Context,
+	urls map[string]string,
+	userID string,
+	meta map[string]models.URLMeta,
+) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
+	now := time.Now()
+	event := fileEvent{Type: eventCreate, At: now, UserID: userID, URLs: make([]fileURL, 0, len(urls))}
+	fullURLs := make(map[string]struct{}, len(urls))
 	for k, v := range urls {
-		f.writeURLInFile(k, v)
+		if f.memory.exists(k) {
+			return fmt.Errorf("failed to save shortURL %s: %w", k, myErrors.ErrKeyAlreadyExists)
+		}
+		if _, exists := fullURLs[v]; exists || f.memory.GetShortURL(ctx, v) != "" {
+			return myErrors.ErrURLAlreadySaved
+		}
+		fullURLs[v] = struct{}{}
+		event.URLs = append(event.URLs, fileURL{
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			ExpiresAt:   optionalTime(meta[k].ExpiresAt),
+			Tags:        meta[k].Tags,
+			ShortURL:    k,
+			OriginalURL: v,
+			Title:       meta[k].Title,
+		})
 	}
 
+	if err := f.write(event); err != nil {
+		return fmt.Errorf("failed to save URL slice %w", err)
+	}
 	return nil
 }
 
-func (f *File) GetURLByUserID(ctx context.Context, userID string) map[string]string {
-	return f.memory.GetURLByUserID(ctx, userID)
+func (f *File) GetURLByUserID(ctx context.Context, query models.URLQuery) ([]models.UsersURLs, error) {
+	return f.memory.GetURLByUserID(ctx, query)
 }
 
-func (f *File) SetDeletedFlag(ctx context.Context, userID string, shortURL string) error {
-	return f.memory.SetDeletedFlag(ctx, userID, shortURL)
+func (f *File) ExportURLs(ctx context.Context, userID string, yield func(models.ExportedURL) error) error {
+	return f.memory.ExportURLs(ctx, userID, yield)
 }
 
-func (f *File) GetPing(ctx context.Context) error {
-	return nil
+// SetDeletedFlag marks the short URLs of userID as deleted. Unknown short URLs
+// and the ones of other users are skipped.
+func (f *File) SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	owned := make([]string, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		if url, found := f.memory.get(shortURL); found && url.userID == userID {
+			owned = append(owned, shortURL)
+		}
+	}
+	if len(owned) == 0 {
+		return nil
+	}
+
+	return f.write(fileEvent{Type: eventDelete, At: time.Now(), UserID: userID, ShortURLs: owned})
 }
 
-func (f *File) Close() error {
-	if err := f.file.Close(); err != nil {
-		return fmt.Errorf("failed to close file: %w", err)
+func (f *File) GetExpiration(ctx context.Context, shortURL string) (time.Time, error) {
+	return f.memory.GetExpiration(ctx, shortURL)
+}
+
+func (f *File) SetExpiration(ctx context.Context, shortURL string, expiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.memory.exists(shortURL) {
+		return fmt.Errorf("failed to set expiration for short_url=%s", shortURL)
 	}
-	return nil
+
+	return f.write(fileEvent{Type: eventExpire, At: time.Now(), ShortURL: shortURL, ExpiresAt: &expiresAt})
 }
 
-func (f *File) writeURLInFile(shortURL string, fullURL string) {
-	writer := bufio.NewWriter(f.file)
+func (f *File) SetTitle(ctx context.Context, shortURL string, title string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	u := URLsJSON{
-		UUID:        strconv.Itoa(len(f.memory.urls)),
-		ShortURL:    shortURL,
-		OriginalURL: fullURL}
+	if !f.memory.exists(shortURL) {
+		return fmt.Errorf("failed to set title for short_url=%s: %w", shortURL, myErrors.ErrURLNotFound)
+	}
 
-	data, err := json.Marshal(u)
-	if err != nil {
-		f.logger.Sugar().Errorf("failed to write masrshaling data %w", err)
-		return
+	return f.write(fileEvent{Type: eventTitle, At: time.Now(), ShortURL: shortURL, Title: title})
+}
+
+// SetTags replaces the tags of a url of userID.
+func (f *File) SetTags(ctx context.Context, userID string, shortURL string, tags []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if url, found := f.memory.get(shortURL); !found || url.userID != userID {
+		return fmt.Errorf("failed to set tags for short_url=%s: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+
+	return f.write(fileEvent{Type: eventTags, At: time.Now(), UserID: userID, ShortURL: shortURL, Tags: tags})
+}
+
+func (f *File) FlagExpired(ctx context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	expired := make([]string, 0)
+	f.memory.forEach(func(shortURL string, url URLInfo) {
+		if !url.DeletedFlag && isExpired(url.expiresAt) {
+			expired = append(expired, shortURL)
+		}
+	})
+	if len(expired) == 0 {
+		return 0, nil
 	}
 
-	if _, err := writer.Write(data); err != nil {
-		f.logger.Sugar().Errorf("failed to write data into temp file %w", err)
-		return
+	if err := f.write(fileEvent{Type: eventExpired, At: time.Now(), ShortURLs: expired}); err != nil {
+		return 0, err
 	}
+	return int64(len(expired)), nil
+}
+
+// LeaseIDs logs the end of the lease, so the counter is not reused after a
+// restart.
+func (f *File) LeaseIDs(ctx context.Context, count uint64) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.memory.idsMu.Lock()
+	start := f.memory.peekNextID()
+	f.memory.idsMu.Unlock()
+
+	if err := f.write(fileEvent{Type: eventLease, At: time.Now(), NextID: start + count}); err != nil {
+		return 0, fmt.Errorf("failed to lease %d ids: %w", count, err)
+	}
+	return start, nil
+}
 
-	if err := writer.WriteByte('\n'); err != nil {
-		f.logger.Sugar().Errorf("failed to write newline into temp file %w", err)
-		return
+func (f *File) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	writer := bufio.NewWriter(f.clicksFile)
+	for _, click := range clicks {
+		data, err := json.Marshal(click)
+		if err != nil {
+			return fmt.Errorf("failed to marshal click %w", err)
+		}
+		if _, err := writer.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write click into clicks file %w", err)
+		}
 	}
 
 	if err := writer.Flush(); err != nil {
-		f.logger.Sugar().Errorf("failed to flush temp file %w", err)
-		return
+		return fmt.Errorf("failed to flush clicks file %w", err)
+	}
+	if err := f.flush(f.clicksFile, f.path+clicksFileSuffix); err != nil {
+		return err
+	}
+	f.clickLines += len(clicks)
+
+	return f.memory.SaveClicks(ctx, clicks)
+}
+
+func (f *File) GetClickStats(ctx context.Context, shortURL string) (models.URLStats, error) {
+	return f.memory.GetClickStats(ctx, shortURL)
+}
+
+func (f *File) GetURLsCount(ctx context.Context) (int, error) {
+	return f.memory.GetURLsCount(ctx)
+}
+
+func (f *File) GetUsersCount(ctx context.Context) (int, error) {
+	return f.memory.GetUsersCount(ctx)
+}
+
+func (f *File) GetPing(ctx context.Context) error {
+	return nil
+}
+
+// Close stops the background sync and compaction and flushes the files.
+func (f *File) Close() error {
+	if f.stop != nil {
+		close(f.stop)
+		<-f.done
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var errs []error
+	if f.options.Sync != FileSyncNever {
+		if err := f.file.Sync(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to sync %s: %w", f.path, err))
+		}
+		if err := f.clicksFile.Sync(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to sync %s: %w", f.path+clicksFileSuffix, err))
+		}
+	}
+	if err := f.file.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close file: %w", err))
+	}
+	if err := f.clicksFile.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close clicks file: %w", err))
 	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestFileMetadata(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	store, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, "titled", "https://titled.ru", "", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "plain", "https://plain.ru", "", models.URLMeta{}))
+	time.Sleep(time.Millisecond)
+	require.NoError(t, store.SetTitle(ctx, "titled", "Titled"))
+	require.NoError(t, store.SetTags(ctx, "", "titled", []string{"home", "work/reports"}))
+	require.NoError(t, store.SetExpiration(ctx, "plain", time.Now().Add(time.Hour)))
+	assert.Error(t, store.SetTitle(ctx, "unknown", "Unknown"), "negativ test: unknown short URL")
+
+	before, err := store.GetURLByUserID(ctx, models.URLQuery{})
+	require.NoError(t, err)
+	require.Len(t, before, 2)
+	require.NoError(t, store.Close())
+
+	for _, url := range before {
+		assert.True(t, url.UpdatedAt.After(url.CreatedAt), url.ShortURL)
+	}
+
+	reopened, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reopened.Close()) }()
+
+	after, err := reopened.GetURLByUserID(ctx, models.URLQuery{})
+	require.NoError(t, err)
+	require.Len(t, after, 2)
+	for i := range before {
+		assert.Equal(t, before[i].ShortURL, after[i].ShortURL)
+		assert.Equal(t, before[i].Title, after[i].Title)
+		assert.Equal(t, before[i].Tags, after[i].Tags)
+		assert.True(t, before[i].CreatedAt.Equal(after[i].CreatedAt), before[i].ShortURL)
+		assert.True(t, before[i].UpdatedAt.Equal(after[i].UpdatedAt), before[i].ShortURL)
+	}
+	assert.Equal(t, "Titled", after[0].Title)
+	assert.Equal(t, []string{"home", "work/reports"}, after[0].Tags)
+}
+
+func TestFileRecoversUsersAndDeletions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	store, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, "kept", "https://kept.ru", "user1", models.URLMeta{}))
+	require.NoError(t, store.SaveURLBatch(ctx, map[string]string{
+		"deleted": "https://deleted.ru",
+		"foreign": "https://foreign.ru",
+	}, "user1", nil))
+	require.NoError(t, store.SaveURL(ctx, "other", "https://other.ru", "user2", models.URLMeta{}))
+	require.NoError(t, store.SetDeletedFlag(ctx, "user1", []string{"deleted", "other"}))
+	require.NoError(t, store.Close())
+
+	reopened, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reopened.Close()) }()
+
+	urls, err := reopened.GetURLByUserID(ctx, models.URLQuery{UserID: "user1"})
+	require.NoError(t, err)
+	assert.Len(t, urls, 3)
+
+	_, deleted, err := reopened.GetFullURL(ctx, "deleted")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, deleted, err = reopened.GetFullURL(ctx, "other")
+	require.NoError(t, err)
+	assert.False(t, deleted, "negativ test: url of another user")
+
+	users, err := reopened.GetUsersCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users)
+}
+
+func TestFileToleratesCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		want    []string
+	}{
+		{
+			name: "positive test: torn last record",
+			corrupt: func(data []byte) []byte {
+				return data[:len(data)-5]
+			},
+			want: []string{"first", "second"},
+		},
+		{
+			name: "positive test: corrupt record in the middle",
+			corrupt: func(data []byte) []byte {
+				lines := bytes.SplitAfter(data, []byte("\n"))
+				lines[1] = append([]byte("0000000"), lines[1][7:]...)
+				return bytes.Join(lines, nil)
+			},
+			want: []string{"first", "third"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "urls.json")
+
+			store, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+			require.NoError(t, err)
+			for _, shortURL := range []string{"first", "second", "third"} {
+				require.NoError(t, store.SaveURL(ctx, shortURL, "https://"+shortURL+".ru", "user", models.URLMeta{}))
+			}
+			require.NoError(t, store.Close())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, tt.corrupt(data), 0600))
+
+			reopened, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+			require.NoError(t, err)
+			require.NoError(t, reopened.SaveURL(ctx, "fourth", "https://fourth.ru", "user", models.URLMeta{}))
+			require.NoError(t, reopened.Close())
+
+			reopened, err = NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+			require.NoError(t, err)
+			defer func() { require.NoError(t, reopened.Close()) }()
+
+			urls, err := reopened.GetURLByUserID(ctx, models.URLQuery{UserID: "user"})
+			require.NoError(t, err)
+			shortURLs := make([]string, 0, len(urls))
+			for _, url := range urls {
+				shortURLs = append(shortURLs, url.ShortURL)
+			}
+			assert.ElementsMatch(t, append(tt.want, "fourth"), shortURLs)
+		})
+	}
+}
+
+func TestFileCompaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	store, err := NewFile(path, FileOptions{Sync: FileSyncNever}, zap.NewNop())
+	require.NoError(t, err)
+	file, ok := store.(*File)
+	require.True(t, ok)
+
+	require.NoError(t, store.SaveURL(ctx, "short", "https://short.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "gone", "https://gone.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SetDeletedFlag(ctx, "user", []string{"gone"}))
+	leased, err := store.LeaseIDs(ctx, 10)
+	require.NoError(t, err)
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for n := 0; n < minCompactEvents; n++ {
+		require.NoError(t, store.SetTitle(ctx, "short", fmt.Sprintf("Title %d", n)))
+		click := models.Click{Time: day.AddDate(0, 0, n%2), ShortURL: "short", Referer: "https://ya.ru"}
+		require.NoError(t, store.SaveClicks(ctx, []models.Click{click}))
+	}
+	grown, err := os.Stat(path)
+	require.NoError(t, err)
+	grownClicks, err := os.Stat(path + clicksFileSuffix)
+	require.NoError(t, err)
+
+	require.NoError(t, file.compactIfNeeded())
+	compacted, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Less(t, compacted.Size()*10, grown.Size())
+	compactedClicks, err := os.Stat(path + clicksFileSuffix)
+	require.NoError(t, err)
+	assert.Less(t, compactedClicks.Size()*10, grownClicks.Size())
+
+	require.NoError(t, store.SaveURL(ctx, "after", "https://after.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SaveClicks(ctx, []models.Click{{Time: day, ShortURL: "short"}}))
+	require.NoError(t, store.Close())
+
+	reopened, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reopened.Close()) }()
+
+	urls, err := reopened.GetURLByUserID(ctx, models.URLQuery{UserID: "user", Desc: true})
+	require.NoError(t, err)
+	require.Len(t, urls, 3)
+	assert.Equal(t, "after", urls[0].ShortURL)
+	byShortURL := make(map[string]models.UsersURLs, len(urls))
+	for _, url := range urls {
+		byShortURL[url.ShortURL] = url
+	}
+	assert.Equal(t, fmt.Sprintf("Title %d", minCompactEvents-1), byShortURL["short"].Title)
+	assert.True(t, byShortURL["gone"].Deleted)
+
+	next, err := reopened.LeaseIDs(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, leased+10, next, "compaction keeps the id counter")
+
+	stats, err := reopened.GetClickStats(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, int64(minCompactEvents+1), stats.Total, "compaction keeps the clicks")
+	assert.Equal(t, []models.DayClicks{
+		{Day: "2024-05-01", Clicks: minCompactEvents/2 + 1},
+		{Day: "2024-05-02", Clicks: minCompactEvents / 2},
+	}, stats.Days)
+}
+
+func TestFileFailedCompaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	store, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	file, ok := store.(*File)
+	require.True(t, ok)
+	require.NoError(t, store.SaveURL(ctx, "before", "https://before.ru", "user", models.URLMeta{}))
+
+	require.NoError(t, os.Mkdir(path+compactFileSuffix, 0o755))
+	require.Error(t, file.compact(), "negativ test: the snapshot can't be created")
+
+	require.NoError(t, store.SaveURL(ctx, "after", "https://after.ru", "user", models.URLMeta{}),
+		"positive test: the log is still written after a failed compaction")
+	require.NoError(t, store.Close())
+
+	reopened, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reopened.Close()) }()
+	urls, err := reopened.GetURLByUserID(ctx, models.URLQuery{UserID: "user"})
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func TestFileLegacyFormat(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	legacy := `{"uuid":"1","short_url":"old","original_url":"https://old.ru"}
+{"uuid":"2","short_url":"exp","original_url":"https://exp.ru"}
+{"uuid":"2","short_url":"exp","original_url":"https://exp.ru","title":"Expiring","expires_at":"2100-01-01T00:00:00Z"}
+`
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0600))
+
+	store, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, "new", "https://new.ru", "", models.URLMeta{}))
+	require.NoError(t, store.Close())
+
+	reopened, err := NewFile(path, FileOptions{Sync: FileSyncAlways}, zap.NewNop())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reopened.Close()) }()
+
+	urls, err := reopened.GetURLByUserID(ctx, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Len(t, urls, 3)
+	for _, shortURL := range []string{"old", "exp", "new"} {
+		fullURL, _, err := reopened.GetFullURL(ctx, shortURL)
+		require.NoError(t, err)
+		assert.Equal(t, "https://"+shortURL+".ru", fullURL)
+	}
+}
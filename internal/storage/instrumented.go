@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/tiunovvv/go-yandex-shortener/internal/metrics"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/tiunovvv/go-yandex-shortener/internal/storage")
+
+// Instrumented reports the latency of every call to the wrapped Store and
+// traces it as a child span of the caller.
+type Instrumented struct {
+	store   Store
+	metrics *metrics.Metrics
+}
+
+func NewInstrumented(store Store, m *metrics.Metrics) Store {
+	return &Instrumented{store: store, metrics: m}
+}
+
+// start opens the span of method. The returned function ends it and records
+// the latency.
+func (s *Instrumented) start(ctx context.Context, method string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "store."+method, trace.WithSpanKind(trace.SpanKindInternal))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		s.metrics.ObserveStore(method, time.Since(start), err)
+	}
+}
+
+func (s *Instrumented) GetShortURL(ctx context.Context, fullURL string) string {
+	ctx, end := s.start(ctx, "GetShortURL")
+	defer end(nil)
+	return s.store.GetShortURL(ctx, fullURL)
+}
+
+func (s *Instrumented) GetFullURL(ctx context.Context, shortURL string) (fullURL string, deleted bool, err error) {
+	ctx, end := s.start(ctx, "GetFullURL")
+	defer func() { end(err) }()
+	return s.store.GetFullURL(ctx, shortURL)
+}
+
+func (s *Instrumented) GetURLByUserID(ctx context.Context, query models.URLQuery) (urls []models.UsersURLs, err error) {
+	ctx, end := s.start(ctx, "GetURLByUserID")
+	defer func() { end(err) }()
+	return s.store.GetURLByUserID(ctx, query)
+}
+
+func (s *Instrumented) ExportURLs(
+	ctx context.Context,
+	userID string,
+	yield func(models.ExportedURL) error,
+) (err error) {
+	ctx, end := s.start(ctx, "ExportURLs")
+	defer func() { end(err) }()
+	return s.store.ExportURLs(ctx, userID, yield)
+}
+
+func (s *Instrumented) SaveURL(
+	ctx context.Context,
+	shortURL string,
+	fullURL string,
+	userID string,
+	meta models.URLMeta,
+) (err error) {
+	ctx, end := s.start(ctx, "SaveURL")
+	defer func() { end(err) }()
+	return s.store.SaveURL(ctx, shortURL, fullURL, userID, meta)
+}
+
+func (s *Instrumented) SaveURLBatch(
+	ctx context.Context,
+	urls map[string]string,
+	userID string,
+	meta map[string]models.URLMeta,
+) (err error) {
+	ctx, end := s.start(ctx, "SaveURLBatch")
+	defer func() { end(err) }()
+	return s.store.SaveURLBatch(ctx, urls, userID, meta)
+}
+
+func (s *Instrumented) SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) (err error) {
+	ctx, end := s.start(ctx, "SetDeletedFlag")
+	defer func() { end(err) }()
+	return s.store.SetDeletedFlag(ctx, userID, shortURLs)
+}
+
+func (s *Instrumented) GetExpiration(ctx context.Context, shortURL string) (expiresAt time.Time, err error) {
+	ctx, end := s.start(ctx, "GetExpiration")
+	defer func() { end(err) }()
+	return s.store.GetExpiration(ctx, shortURL)
+}
+
+func (s *Instrumented) SetExpiration(ctx context.Context, shortURL string, expiresAt time.Time) (err error) {
+	ctx, end := s.start(ctx, "SetExpiration")
+	defer func() { end(err) }()
+	return s.store.SetExpiration(ctx, shortURL, expiresAt)
+}
+
+func (s *Instrumented) SetTitle(ctx context.Context, shortURL string, title string) (err error) {
+	ctx, end := s.start(ctx, "SetTitle")
+	defer func() { end(err) }()
+	return s.store.SetTitle(ctx, shortURL, title)
+}
+
+func (s *Instrumented) SetTags(ctx context.Context, userID string, shortURL string, tags []string) (err error) {
+	ctx, end := s.start(ctx, "SetTags")
+	defer func() { end(err) }()
+	return s.store.SetTags(ctx, userID, shortURL, tags)
+}
+
+func (s *Instrumented) FlagExpired(ctx context.Context) (count int64, err error) {
+	ctx, end := s.start(ctx, "FlagExpired")
+	defer func() { end(err) }()
+	return s.store.FlagExpired(ctx)
+}
+
+func (s *Instrumented) LeaseIDs(ctx context.Context, count uint64) (start uint64, err error) {
+	ctx, end := s.start(ctx, "LeaseIDs")
+	defer func() { end(err) }()
+	return s.store.LeaseIDs(ctx, count)
+}
+
+func (s *Instrumented) SaveClicks(ctx context.Context, clicks []models.Click) (err error) {
+	ctx, end := s.start(ctx, "SaveClicks")
+	defer func() { end(err) }()
+	return s.store.SaveClicks(ctx, clicks)
+}
+
+func (s *Instrumented) GetClickStats(ctx context.Context, shortURL string) (stats models.URLStats, err error) {
+	ctx, end := s.start(ctx, "GetClickStats")
+	defer func() { end(err) }()
+	return s.store.GetClickStats(ctx, shortURL)
+}
+
+func (s *Instrumented) GetURLsCount(ctx context.Context) (count int, err error) {
+	ctx, end := s.start(ctx, "GetURLsCount")
+	defer func() { end(err) }()
+	return s.store.GetURLsCount(ctx)
+}
+
+func (s *Instrumented) GetUsersCount(ctx context.Context) (count int, err error) {
+	ctx, end := s.start(ctx, "GetUsersCount")
+	defer func() { end(err) }()
+	return s.store.GetUsersCount(ctx)
+}
+
+func (s *Instrumented) GetPing(ctx context.Context) (err error) {
+	ctx, end := s.start(ctx, "GetPing")
+	defer func() { end(err) }()
+	return s.store.GetPing(ctx)
+}
+
+func (s *Instrumented) Close() (err error) {
+	_, end := s.start(context.Background(), "Close")
+	defer func() { end(err) }()
+	return s.store.Close()
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cachedURL is a result of GetFullURL kept in a cache. ExpiresAt is zero
+// when the url never expires.
+type cachedURL struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	FullURL   string    `json:"full_url"`
+	Deleted   bool      `json:"deleted"`
+}
+
+type lruEntry struct {
+	expiresAt time.Time
+	key       string
+	value     cachedURL
+}
+
+// lruCache is a size-bounded cache evicting the least recently used entries.
+// Entries live no longer than ttl and than the url they keep.
+type lruCache struct {
+	items map[string]*list.Element
+	order *list.List
+	ttl   time.Duration
+	mu    sync.Mutex
+	size  int
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+		ttl:   ttl,
+		size:  size,
+	}
+}
+
+func (c *lruCache) Get(key string) (cachedURL, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return cachedURL{}, false
+	}
+	entry, _ := element.Value.(*lruEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.removeElement(element)
+		return cachedURL{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *lruCache) Set(key string, value cachedURL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+	if !value.ExpiresAt.IsZero() && value.ExpiresAt.Before(expiresAt) {
+		expiresAt = value.ExpiresAt
+	}
+	if element, ok := c.items[key]; ok {
+		entry, _ := element.Value.(*lruEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *lruCache) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := c.items[key]; ok {
+			c.removeElement(element)
+		}
+	}
+}
+
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *lruCache) removeElement(element *list.Element) {
+	entry, _ := element.Value.(*lruEntry)
+	delete(c.items, entry.key)
+	c.order.Remove(element)
+}
@@ -3,89 +3,473 @@ package storage
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
 
 	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+)
+
+const (
+	// memoryShards is the number of independently locked parts of the urls.
+	memoryShards   = 32
+	clickDayLayout = "2006-01-02"
 )
 
 type URLInfo struct {
+	expiresAt   time.Time
+	createdAt   time.Time
+	updatedAt   time.Time
+	tags        []string
 	fullURL     string
 	userID      string
+	title       string
 	DeletedFlag bool
 }
-type Memory struct {
+
+type memoryShard struct {
 	urls map[string]URLInfo
+	mu   sync.RWMutex
+}
+
+// Memory keeps urls in shards picked by the hash of the short URL, so reads
+// and updates of different urls don't wait for each other. The full URL and
+// user indexes are guarded by indexMu, which saves hold for writing so that
+// the uniqueness checks and the inserts are atomic. indexMu is always locked
+// before a shard.
+type Memory struct {
+	byFullURL map[string]string
+	byUser    map[string]map[string]struct{}
+	clicks    map[string]map[string]int64
+	shards    [memoryShards]memoryShard
+	indexMu   sync.RWMutex
+	clicksMu  sync.RWMutex
+	idsMu     sync.Mutex
+	nextID    uint64
 }
 
 func NewMemory() Store {
-	return &Memory{map[string]URLInfo{}}
+	return newMemory()
 }
 
-func (i *Memory) GetShortURL(ctx context.Context, fullURL string) string {
-	for key, value := range i.urls {
-		if value.fullURL == fullURL {
-			return key
+func newMemory() *Memory {
+	m := &Memory{
+		byFullURL: make(map[string]string),
+		byUser:    make(map[string]map[string]struct{}),
+		clicks:    make(map[string]map[string]int64),
+	}
+	for i := range m.shards {
+		m.shards[i].urls = make(map[string]URLInfo)
+	}
+	return m
+}
+
+func (i *Memory) shard(shortURL string) *memoryShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(shortURL))
+	return &i.shards[h.Sum32()%memoryShards]
+}
+
+// get returns a copy of the url saved under shortURL.
+func (i *Memory) get(shortURL string) (URLInfo, bool) {
+	shard := i.shard(shortURL)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	url, found := shard.urls[shortURL]
+	return url, found
+}
+
+// update applies change to the url saved under shortURL while its shard is
+// locked. The url is kept only when change returns true.
+func (i *Memory) update(shortURL string, change func(url *URLInfo) bool) bool {
+	shard := i.shard(shortURL)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	url, found := shard.urls[shortURL]
+	if !found || !change(&url) {
+		return false
+	}
+	shard.urls[shortURL] = url
+	return true
+}
+
+// userURLs returns the short URLs of userID.
+func (i *Memory) userURLs(userID string) []string {
+	i.indexMu.RLock()
+	defer i.indexMu.RUnlock()
+
+	shortURLs := make([]string, 0, len(i.byUser[userID]))
+	for shortURL := range i.byUser[userID] {
+		shortURLs = append(shortURLs, shortURL)
+	}
+	return shortURLs
+}
+
+// insert adds a url checked by the caller holding indexMu for writing.
+func (i *Memory) insert(shortURL string, url URLInfo) {
+	shard := i.shard(shortURL)
+	shard.mu.Lock()
+	shard.urls[shortURL] = url
+	shard.mu.Unlock()
+
+	i.byFullURL[url.fullURL] = shortURL
+	if i.byUser[url.userID] == nil {
+		i.byUser[url.userID] = make(map[string]struct{})
+	}
+	i.byUser[url.userID][shortURL] = struct{}{}
+}
+
+// restore saves a url with its whole state unless the short or the full URL
+// is already saved.
+func (i *Memory) restore(shortURL string, url URLInfo) bool {
+	i.indexMu.Lock()
+	defer i.indexMu.Unlock()
+
+	if _, exists := i.byFullURL[url.fullURL]; exists || i.exists(shortURL) {
+		return false
+	}
+	i.insert(shortURL, url)
+	return true
+}
+
+// forEach calls fn for every url while holding the lock of its shard.
+func (i *Memory) forEach(fn func(shortURL string, url URLInfo)) {
+	for s := range i.shards {
+		shard := &i.shards[s]
+		shard.mu.RLock()
+		for key, value := range shard.urls {
+			fn(key, value)
 		}
+		shard.mu.RUnlock()
 	}
-	return ""
+}
+
+func (i *Memory) exists(shortURL string) bool {
+	_, found := i.get(shortURL)
+	return found
+}
+
+func (i *Memory) GetShortURL(ctx context.Context, fullURL string) string {
+	i.indexMu.RLock()
+	defer i.indexMu.RUnlock()
+	return i.byFullURL[fullURL]
 }
 
 func (i *Memory) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
-	if urlInfo, found := i.urls[shortURL]; found {
+	if urlInfo, found := i.get(shortURL); found {
+		if isExpired(urlInfo.expiresAt) {
+			return "", false, fmt.Errorf("URL `%s`: %w", shortURL, myErrors.ErrURLExpired)
+		}
 		return urlInfo.fullURL, urlInfo.DeletedFlag, nil
 	}
 	return "", false, fmt.Errorf("URL `%s` not found", shortURL)
 }
 
-func (i *Memory) SaveURL(ctx context.Context, shortURL string, fullURL string, userID string) error {
-	for _, value := range i.urls {
-		if value.fullURL == fullURL {
-			return myErrors.ErrURLAlreadySaved
-		}
+func (i *Memory) SaveURL(
+	ctx context.Context,
+	shortURL string,
+	fullURL string,
+	userID string,
+	meta models.URLMeta,
+) error {
+	i.indexMu.Lock()
+	defer i.indexMu.Unlock()
+
+	if _, exists := i.byFullURL[fullURL]; exists {
+		return myErrors.ErrURLAlreadySaved
 	}
 
-	if _, exists := i.urls[shortURL]; exists {
+	if i.exists(shortURL) {
 		return fmt.Errorf("failed to save shortURL %s: %w", shortURL, myErrors.ErrKeyAlreadyExists)
 	}
-	i.urls[shortURL] = URLInfo{fullURL, userID, false}
+	now := time.Now()
+	i.insert(shortURL, URLInfo{
+		fullURL:   fullURL,
+		userID:    userID,
+		createdAt: now,
+		updatedAt: now,
+		expiresAt: meta.ExpiresAt,
+		title:     meta.Title,
+		tags:      meta.Tags,
+	})
 
 	return nil
 }
 
-func (i *Memory) SaveURLBatch(ctx context.Context, urls map[string]string, userID string) error {
+// SaveURLBatch saves all urls or none of them, each with its meta if any.
+func (i *Memory) SaveURLBatch(
+	ctx context.Context,
+	urls map[string]string,
+	userID string,
+	meta map[string]models.URLMeta,
+) error {
+	i.indexMu.Lock()
+	defer i.indexMu.Unlock()
+
+	fullURLs := make(map[string]struct{}, len(urls))
 	for k, v := range urls {
-		if err := i.SaveURL(ctx, k, v, userID); err != nil {
-			return myErrors.ErrKeyAlreadyExists
+		if i.exists(k) {
+			return fmt.Errorf("failed to save shortURL %s: %w", k, myErrors.ErrKeyAlreadyExists)
+		}
+		if _, exists := i.byFullURL[v]; exists {
+			return myErrors.ErrURLAlreadySaved
 		}
+		if _, exists := fullURLs[v]; exists {
+			return myErrors.ErrURLAlreadySaved
+		}
+		fullURLs[v] = struct{}{}
+	}
+
+	createdAt := time.Now()
+	for k, v := range urls {
+		i.insert(k, URLInfo{
+			fullURL:   v,
+			userID:    userID,
+			createdAt: createdAt,
+			updatedAt: createdAt,
+			expiresAt: meta[k].ExpiresAt,
+			title:     meta[k].Title,
+			tags:      meta[k].Tags,
+		})
 	}
 
 	return nil
 }
 
-func (i *Memory) GetURLByUserID(ctx context.Context, userID string) map[string]string {
-	urls := make(map[string]string)
-	for key, value := range i.urls {
-		if value.userID == userID {
-			urls[key] = value.fullURL
+func (i *Memory) GetURLByUserID(ctx context.Context, query models.URLQuery) ([]models.UsersURLs, error) {
+	shortURLs := i.userURLs(query.UserID)
+	if query.ShortURL != "" {
+		shortURLs = []string{query.ShortURL}
+	}
+
+	urls := make([]models.UsersURLs, 0, len(shortURLs))
+	for _, key := range shortURLs {
+		value, found := i.get(key)
+		if !found ||
+			!matchURLQuery(query, key, value.fullURL, value.userID, value.DeletedFlag, value.tags) ||
+			!afterCursor(query, value.createdAt, key) {
+			continue
+		}
+		urls = append(urls, models.UsersURLs{
+			ShortURL:    key,
+			OriginalURL: value.fullURL,
+			CreatedAt:   value.createdAt,
+			UpdatedAt:   value.updatedAt,
+			Tags:        append([]string(nil), value.tags...),
+			Title:       value.title,
+			Deleted:     value.DeletedFlag,
+		})
+	}
+	return pageURLs(query, urls), nil
+}
+
+// ExportURLs passes the urls of userID to yield in the order of short URLs.
+// No lock is held while yield runs.
+func (i *Memory) ExportURLs(ctx context.Context, userID string, yield func(models.ExportedURL) error) error {
+	shortURLs := i.userURLs(userID)
+	sort.Strings(shortURLs)
+
+	for _, shortURL := range shortURLs {
+		value, found := i.get(shortURL)
+		if !found {
+			continue
 		}
+		clicks := i.totalClicks(shortURL)
+		createdAt := value.createdAt
+		err := yield(models.ExportedURL{
+			CreatedAt:   &createdAt,
+			ShortURL:    shortURL,
+			OriginalURL: value.fullURL,
+			Deleted:     value.DeletedFlag,
+			Clicks:      &clicks,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDeletedFlag marks the short URLs of userID as deleted. Unknown short URLs
+// and the ones of other users are skipped.
+func (i *Memory) SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) error {
+	for _, shortURL := range shortURLs {
+		i.update(shortURL, func(url *URLInfo) bool {
+			if url.userID != userID {
+				return false
+			}
+			url.DeletedFlag = true
+			url.updatedAt = time.Now()
+			return true
+		})
+	}
+	return nil
+}
+
+// GetExpiration returns the expiration time of a url, zero when it never
+// expires.
+func (i *Memory) GetExpiration(ctx context.Context, shortURL string) (time.Time, error) {
+	url, found := i.get(shortURL)
+	if !found {
+		return time.Time{}, fmt.Errorf("URL `%s`: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+	return url.expiresAt, nil
+}
+
+func (i *Memory) SetExpiration(ctx context.Context, shortURL string, expiresAt time.Time) error {
+	updated := i.update(shortURL, func(url *URLInfo) bool {
+		url.expiresAt = expiresAt
+		url.updatedAt = time.Now()
+		return true
+	})
+
+	if !updated {
+		return fmt.Errorf("failed to set expiration for short_url=%s", shortURL)
+	}
+	return nil
+}
+
+func (i *Memory) SetTitle(ctx context.Context, shortURL string, title string) error {
+	updated := i.update(shortURL, func(url *URLInfo) bool {
+		url.title = title
+		url.updatedAt = time.Now()
+		return true
+	})
+
+	if !updated {
+		return fmt.Errorf("failed to set title for short_url=%s: %w", shortURL, myErrors.ErrURLNotFound)
 	}
-	return urls
+	return nil
 }
 
-func (i *Memory) SetDeletedFlag(ctx context.Context, userID string, shortURL string) error {
-	url, exists := i.urls[shortURL]
+// SetTags replaces the tags of a url of userID.
+func (i *Memory) SetTags(ctx context.Context, userID string, shortURL string, tags []string) error {
+	updated := i.update(shortURL, func(url *URLInfo) bool {
+		if url.userID != userID {
+			return false
+		}
+		url.tags = append([]string(nil), tags...)
+		url.updatedAt = time.Now()
+		return true
+	})
+
+	if !updated {
+		return fmt.Errorf("failed to set tags for short_url=%s: %w", shortURL, myErrors.ErrURLNotFound)
+	}
+	return nil
+}
 
-	if !exists {
-		return fmt.Errorf("failed to update deleted flag for short_url=%s", shortURL)
+func (i *Memory) FlagExpired(ctx context.Context) (int64, error) {
+	var count int64
+	for s := range i.shards {
+		shard := &i.shards[s]
+		shard.mu.Lock()
+		for key, value := range shard.urls {
+			if !value.DeletedFlag && isExpired(value.expiresAt) {
+				value.DeletedFlag = true
+				value.updatedAt = time.Now()
+				shard.urls[key] = value
+				count++
+			}
+		}
+		shard.mu.Unlock()
 	}
+	return count, nil
+}
 
-	if url.userID != userID {
-		return fmt.Errorf("failed to update deleted flag for short_url=%s, different userID ", shortURL)
+func (i *Memory) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	for _, click := range clicks {
+		i.addClicks(click.ShortURL, click.Time.UTC().Format(clickDayLayout), 1)
 	}
-	url.DeletedFlag = true
-	i.urls[shortURL] = url
 	return nil
 }
 
+// addClicks counts clicks of shortURL in day. Only the counts are kept, as
+// they are all the stats are made of.
+func (i *Memory) addClicks(shortURL string, day string, clicks int64) {
+	i.clicksMu.Lock()
+	defer i.clicksMu.Unlock()
+
+	days, found := i.clicks[shortURL]
+	if !found {
+		days = make(map[string]int64)
+		i.clicks[shortURL] = days
+	}
+	days[day] += clicks
+}
+
+// forEachClickDay calls fn for the clicks of every short URL and day.
+func (i *Memory) forEachClickDay(fn func(shortURL string, day string, clicks int64)) {
+	i.clicksMu.RLock()
+	defer i.clicksMu.RUnlock()
+
+	for shortURL, days := range i.clicks {
+		for day, clicks := range days {
+			fn(shortURL, day, clicks)
+		}
+	}
+}
+
+func (i *Memory) clickDays() int {
+	i.clicksMu.RLock()
+	defer i.clicksMu.RUnlock()
+
+	var count int
+	for _, days := range i.clicks {
+		count += len(days)
+	}
+	return count
+}
+
+func (i *Memory) totalClicks(shortURL string) int64 {
+	i.clicksMu.RLock()
+	defer i.clicksMu.RUnlock()
+
+	var total int64
+	for _, clicks := range i.clicks[shortURL] {
+		total += clicks
+	}
+	return total
+}
+
+func (i *Memory) GetClickStats(ctx context.Context, shortURL string) (models.URLStats, error) {
+	i.clicksMu.RLock()
+	defer i.clicksMu.RUnlock()
+
+	days := i.clicks[shortURL]
+	stats := models.URLStats{
+		ShortURL: shortURL,
+		Days:     make([]models.DayClicks, 0, len(days)),
+	}
+	for day, clicks := range days {
+		stats.Days = append(stats.Days, models.DayClicks{Day: day, Clicks: clicks})
+		stats.Total += clicks
+	}
+	sort.Slice(stats.Days, func(a, b int) bool { return stats.Days[a].Day < stats.Days[b].Day })
+
+	return stats, nil
+}
+
+func (i *Memory) GetURLsCount(ctx context.Context) (int, error) {
+	i.indexMu.RLock()
+	defer i.indexMu.RUnlock()
+	return len(i.byFullURL), nil
+}
+
+func (i *Memory) GetUsersCount(ctx context.Context) (int, error) {
+	i.indexMu.RLock()
+	defer i.indexMu.RUnlock()
+
+	count := len(i.byUser)
+	if _, anonymous := i.byUser[""]; anonymous {
+		count--
+	}
+	return count, nil
+}
+
 func (i *Memory) GetPing(ctx context.Context) error {
 	return nil
 }
@@ -93,3 +477,46 @@ func (i *Memory) GetPing(ctx context.Context) error {
 func (i *Memory) Close() error {
 	return nil
 }
+
+func (i *Memory) LeaseIDs(ctx context.Context, count uint64) (uint64, error) {
+	i.idsMu.Lock()
+	defer i.idsMu.Unlock()
+	start := i.peekNextID()
+	i.nextID = start + count
+	return start, nil
+}
+
+// peekNextID returns the start of the next lease. The caller holds idsMu.
+func (i *Memory) peekNextID() uint64 {
+	if i.nextID == 0 {
+		return firstID()
+	}
+	return i.nextID
+}
+
+// restoreNextID moves the id counter to next unless it is already past it.
+func (i *Memory) restoreNextID(next uint64) {
+	i.idsMu.Lock()
+	defer i.idsMu.Unlock()
+	if next > i.nextID {
+		i.nextID = next
+	}
+}
+
+// firstID starts the id counter of a new store at the current time, so that
+// it continues after the codes generated before the counter was stored.
+func firstID() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+// optionalTime is nil for the zero time.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func isExpired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !time.Now().Before(expiresAt)
+}
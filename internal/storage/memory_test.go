@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+)
+
+// The tests of this file are meant to be run with -race.
+
+const (
+	hammerUsers   = 8
+	hammerPerUser = 200
+)
+
+func TestMemoryConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	store := newMemory()
+
+	var wg sync.WaitGroup
+	for u := 0; u < hammerUsers; u++ {
+		userID := fmt.Sprintf("user%d", u)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < hammerPerUser; n++ {
+				shortURL := fmt.Sprintf("%s-%d", userID, n)
+				assert.NoError(t, store.SaveURL(ctx, shortURL, "https://"+shortURL+".ru", userID, models.URLMeta{}))
+				assert.NoError(t, store.SaveClicks(ctx, []models.Click{{ShortURL: shortURL, Time: time.Now()}}))
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < hammerPerUser; n++ {
+				shortURL := fmt.Sprintf("%s-%d", userID, n)
+				store.GetShortURL(ctx, "https://"+shortURL+".ru")
+				_, _, _ = store.GetFullURL(ctx, shortURL)
+				_ = store.SetTags(ctx, userID, shortURL, []string{"tag"})
+				_ = store.SetTitle(ctx, shortURL, "title")
+				_, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: userID, Limit: 10})
+				assert.NoError(t, err)
+				_, err = store.GetClickStats(ctx, shortURL)
+				assert.NoError(t, err)
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < hammerPerUser; n += 2 {
+				assert.NoError(t, store.SetDeletedFlag(ctx, userID, []string{fmt.Sprintf("%s-%d", userID, n)}))
+				_, err := store.FlagExpired(ctx)
+				assert.NoError(t, err)
+				_, err = store.GetURLsCount(ctx)
+				assert.NoError(t, err)
+				assert.NoError(t, store.ExportURLs(ctx, userID, func(models.ExportedURL) error { return nil }))
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := store.GetURLsCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, hammerUsers*hammerPerUser, count)
+	users, err := store.GetUsersCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, hammerUsers, users)
+
+	for u := 0; u < hammerUsers; u++ {
+		userID := fmt.Sprintf("user%d", u)
+		urls, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: userID})
+		require.NoError(t, err)
+		assert.Len(t, urls, hammerPerUser)
+		for _, url := range urls {
+			assert.Equal(t, url.ShortURL, store.GetShortURL(ctx, url.OriginalURL), "reverse index")
+		}
+	}
+}
+
+func TestMemoryConcurrentSaveIsUnique(t *testing.T) {
+	ctx := context.Background()
+	store := newMemory()
+	const writers = 32
+
+	var saved, alreadySaved, keyExists atomic.Int64
+	count := func(err error) {
+		switch {
+		case err == nil:
+			saved.Add(1)
+		case errors.Is(err, myErrors.ErrURLAlreadySaved):
+			alreadySaved.Add(1)
+		case errors.Is(err, myErrors.ErrKeyAlreadyExists):
+			keyExists.Add(1)
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		w := w
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			count(store.SaveURL(ctx, fmt.Sprintf("same-full-%d", w), "https://same.ru", "user", models.URLMeta{}))
+		}()
+		go func() {
+			defer wg.Done()
+			count(store.SaveURLBatch(ctx, map[string]string{
+				"same-key":                 fmt.Sprintf("https://batch%d.ru", w),
+				fmt.Sprintf("batch-%d", w): fmt.Sprintf("https://batch%d.ru/other", w),
+			}, "user", nil))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(2), saved.Load(), "one url and one batch win")
+	assert.Equal(t, int64(writers-1), alreadySaved.Load())
+	assert.Equal(t, int64(writers-1), keyExists.Load())
+
+	urls, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: "user"})
+	require.NoError(t, err)
+	assert.Len(t, urls, 3, "batches are saved entirely or not at all")
+}
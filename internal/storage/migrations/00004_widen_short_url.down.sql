@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE urls
+ALTER COLUMN short_url TYPE CHAR(8);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE urls
+ALTER COLUMN short_url TYPE VARCHAR(64);
+
+COMMIT;
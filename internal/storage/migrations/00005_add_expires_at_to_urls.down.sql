@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS urls_expires_at_idx;
+
+ALTER TABLE urls
+DROP COLUMN expires_at;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE urls
+ADD COLUMN expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE IF NOT EXISTS clicks(
+    id BIGSERIAL PRIMARY KEY,
+    short_url VARCHAR(64) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referer TEXT,
+    user_agent TEXT,
+    ip_hash CHAR(64)
+);
+
+CREATE INDEX IF NOT EXISTS clicks_short_url_clicked_at_idx ON clicks (short_url, clicked_at);
+
+COMMIT;
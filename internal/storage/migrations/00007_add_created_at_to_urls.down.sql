@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS urls_user_id_created_at_idx;
+
+ALTER TABLE urls
+DROP COLUMN created_at;
+
+COMMIT;
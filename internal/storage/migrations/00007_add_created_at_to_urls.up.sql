@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE urls
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS urls_user_id_created_at_idx ON urls (user_id, created_at, short_url);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE urls
+DROP COLUMN title,
+DROP COLUMN updated_at;
+
+COMMIT;
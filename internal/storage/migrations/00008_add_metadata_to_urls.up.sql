@@ -0,0 +1,13 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE urls
+ADD COLUMN updated_at TIMESTAMPTZ,
+ADD COLUMN title TEXT NOT NULL DEFAULT '';
+
+UPDATE urls SET updated_at = created_at;
+
+ALTER TABLE urls
+ALTER COLUMN updated_at SET DEFAULT NOW(),
+ALTER COLUMN updated_at SET NOT NULL;
+
+COMMIT;
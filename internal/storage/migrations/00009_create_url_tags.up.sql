@@ -0,0 +1,11 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE IF NOT EXISTS url_tags(
+    short_url VARCHAR(64) NOT NULL REFERENCES urls (short_url) ON DELETE CASCADE,
+    tag VARCHAR(64) NOT NULL,
+    PRIMARY KEY (short_url, tag)
+);
+
+CREATE INDEX IF NOT EXISTS url_tags_tag_idx ON url_tags (tag);
+
+COMMIT;
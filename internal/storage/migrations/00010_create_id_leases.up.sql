@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE IF NOT EXISTS id_leases(
+    name VARCHAR(64) PRIMARY KEY,
+    next BIGINT NOT NULL
+);
+
+COMMIT;
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+)
+
+// urlHost returns the host of fullURL in lower case, empty when it doesn't
+// parse.
+func urlHost(fullURL string) string {
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// matchURLQuery reports whether a url passes the filters of query. The
+// cursor is checked by afterCursor.
+func matchURLQuery(query models.URLQuery, shortURL, fullURL, userID string, deleted bool, tags []string) bool {
+	if userID != query.UserID {
+		return false
+	}
+	if query.ShortURL != "" && shortURL != query.ShortURL {
+		return false
+	}
+	if query.Deleted != nil && deleted != *query.Deleted {
+		return false
+	}
+	if query.Domain != "" && !strings.Contains(urlHost(fullURL), strings.ToLower(query.Domain)) {
+		return false
+	}
+	if query.Tag != "" && !hasTag(tags, query.Tag) {
+		return false
+	}
+	return true
+}
+
+// hasTag reports whether tags have tag or a tag in its folder.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag || strings.HasPrefix(t, tag+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// compareURLs orders urls by creation time and then by short URL.
+func compareURLs(createdAt1 time.Time, shortURL1 string, createdAt2 time.Time, shortURL2 string) int {
+	switch {
+	case createdAt1.Before(createdAt2):
+		return -1
+	case createdAt1.After(createdAt2):
+		return 1
+	}
+	return strings.Compare(shortURL1, shortURL2)
+}
+
+// afterCursor reports whether a url goes after the cursor of query in its
+// sort order.
+func afterCursor(query models.URLQuery, createdAt time.Time, shortURL string) bool {
+	if query.After == nil {
+		return true
+	}
+	cmp := compareURLs(createdAt, shortURL, query.After.CreatedAt, query.After.ShortURL)
+	if query.Desc {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+// pageURLs sorts the filtered urls as query asks and cuts them by its limit.
+func pageURLs(query models.URLQuery, urls []models.UsersURLs) []models.UsersURLs {
+	sort.Slice(urls, func(i, j int) bool {
+		cmp := compareURLs(urls[i].CreatedAt, urls[i].ShortURL, urls[j].CreatedAt, urls[j].ShortURL)
+		if query.Desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	if query.Limit > 0 && len(urls) > query.Limit {
+		urls = urls[:query.Limit]
+	}
+	return urls
+}
@@ -2,38 +2,128 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/tiunovvv/go-yandex-shortener/internal/config"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
 	"go.uber.org/zap"
 )
 
 type Store interface {
 	GetShortURL(ctx context.Context, fullURL string) string
 	GetFullURL(ctx context.Context, shortURL string) (string, bool, error)
-	GetURLByUserID(ctx context.Context, userID string) map[string]string
-	SaveURL(ctx context.Context, shortURL string, fullURL string, userID string) error
-	SaveURLBatch(ctx context.Context, urls map[string]string, userID string) error
-	SetDeletedFlag(ctx context.Context, userID string, shortURL string) error
+	GetURLByUserID(ctx context.Context, query models.URLQuery) ([]models.UsersURLs, error)
+	ExportURLs(ctx context.Context, userID string, yield func(models.ExportedURL) error) error
+	SaveURL(ctx context.Context, shortURL string, fullURL string, userID string, meta models.URLMeta) error
+	SaveURLBatch(ctx context.Context, urls map[string]string, userID string, meta map[string]models.URLMeta) error
+	SetDeletedFlag(ctx context.Context, userID string, shortURLs []string) error
+	GetExpiration(ctx context.Context, shortURL string) (time.Time, error)
+	SetExpiration(ctx context.Context, shortURL string, expiresAt time.Time) error
+	SetTitle(ctx context.Context, shortURL string, title string) error
+	SetTags(ctx context.Context, userID string, shortURL string, tags []string) error
+	FlagExpired(ctx context.Context) (int64, error)
+	LeaseIDs(ctx context.Context, count uint64) (uint64, error)
+	SaveClicks(ctx context.Context, clicks []models.Click) error
+	GetClickStats(ctx context.Context, shortURL string) (models.URLStats, error)
+	GetURLsCount(ctx context.Context) (int, error)
+	GetUsersCount(ctx context.Context) (int, error)
 	GetPing(ctx context.Context) error
 	Close() error
 }
 
+// Names of the storage backends.
+const (
+	BackendAuto     = "auto"
+	BackendPostgres = "postgres"
+	BackendBolt     = "bolt"
+	BackendFile     = "file"
+	BackendMemory   = "memory"
+)
+
+// NewStore creates the backend named by config.StorageBackend and fails when
+// it can't be created. The auto backend, also used when the name is empty, is
+// the first of DB, Bolt and File that is configured, or Memory when none is.
+// A configured backend that fails to open fails NewStore in any mode, so a
+// wrong DSN never ends up with the urls kept in memory.
 func NewStore(ctx context.Context, config *config.Config, logger *zap.Logger) (Store, error) {
-	if len(config.DSN) != 0 {
-		store, err := NewDB(ctx, config.DSN, logger)
-		if err == nil {
-			return store, nil
+	backend := config.StorageBackend
+	if backend == BackendAuto || backend == "" {
+		backend = autoBackend(config)
+		if backend == BackendMemory {
+			logger.Sugar().Warn("no storage is configured, urls will be lost on restart")
 		}
-		logger.Sugar().Errorf("failed to create storage using DB: %w", err)
 	}
 
-	if len(config.FilePath) != 0 {
-		store, err := NewFile(config.FilePath, logger)
-		if err == nil {
-			return store, nil
+	switch backend {
+	case BackendPostgres:
+		if len(config.DSN) == 0 {
+			return nil, errors.New("storage backend postgres needs a database DSN")
+		}
+		store, err := NewDB(ctx, config.DSN, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create storage using DB: %w", err)
+		}
+		return store, nil
+	case BackendBolt:
+		if len(config.BoltPath) == 0 {
+			return nil, errors.New("storage backend bolt needs a bolt storage path")
+		}
+		store, err := NewBolt(config.BoltPath, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create storage using Bolt: %w", err)
+		}
+		return store, nil
+	case BackendFile:
+		if len(config.FilePath) == 0 {
+			return nil, errors.New("storage backend file needs a file storage path")
 		}
-		logger.Sugar().Errorf("failed to create storage using File: %w", err)
+		store, err := newFileStore(config, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create storage using File: %w", err)
+		}
+		return store, nil
+	case BackendMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.StorageBackend)
+	}
+}
+
+func newFileStore(config *config.Config, logger *zap.Logger) (Store, error) {
+	return NewFile(config.FilePath, FileOptions{
+		Sync:            FileSync(config.FileSync),
+		CompactInterval: config.FileCompaction,
+	}, logger)
+}
+
+// autoBackend names the first configured of DB, Bolt and File, or Memory.
+func autoBackend(config *config.Config) string {
+	switch {
+	case len(config.DSN) != 0:
+		return BackendPostgres
+	case len(config.BoltPath) != 0:
+		return BackendBolt
+	case len(config.FilePath) != 0:
+		return BackendFile
+	default:
+		return BackendMemory
 	}
+}
 
-	return NewMemory(), nil
+// BackendOf names the backend of a store made by NewStore.
+func BackendOf(store Store) string {
+	switch store.(type) {
+	case *DB:
+		return BackendPostgres
+	case *Bolt:
+		return BackendBolt
+	case *File:
+		return BackendFile
+	case *Memory:
+		return BackendMemory
+	default:
+		return "unknown"
+	}
 }
@@ -0,0 +1,457 @@
+// Package storetest checks that an implementation of storage.Store behaves
+// like the others.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	myErrors "github.com/tiunovvv/go-yandex-shortener/internal/errors"
+	"github.com/tiunovvv/go-yandex-shortener/internal/models"
+	"github.com/tiunovvv/go-yandex-shortener/internal/storage"
+)
+
+const (
+	concurrentUsers   = 4
+	concurrentPerUser = 25
+	contenders        = 16
+	pageURLs          = 7
+	pageLimit         = 3
+)
+
+// Run runs the conformance tests against stores made by newStore. Every test
+// gets a new empty store and closes it.
+func Run(t *testing.T, newStore func(t *testing.T) storage.Store) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, store storage.Store)
+	}{
+		{name: "save duplicates", test: testSaveDuplicates},
+		{name: "batch", test: testBatch},
+		{name: "delete ownership", test: testDeleteOwnership},
+		{name: "deleted flag visibility", test: testDeletedVisibility},
+		{name: "expiration", test: testExpiration},
+		{name: "title and tags", test: testTitleAndTags},
+		{name: "pages", test: testPages},
+		{name: "domain filter", test: testDomain},
+		{name: "clicks", test: testClicks},
+		{name: "counts", test: testCounts},
+		{name: "concurrent saves", test: testConcurrentSaves},
+		{name: "id leases", test: testLeaseIDs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore(t)
+			defer func() { assert.NoError(t, store.Close()) }()
+			tt.test(t, store)
+		})
+	}
+}
+
+func testSaveDuplicates(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "short", "https://full.ru", "user", models.URLMeta{}))
+
+	err := store.SaveURL(ctx, "other", "https://full.ru", "user", models.URLMeta{})
+	assert.ErrorIs(t, err, myErrors.ErrURLAlreadySaved, "negativ test: same full URL")
+	err = store.SaveURL(ctx, "short", "https://other.ru", "user", models.URLMeta{})
+	assert.ErrorIs(t, err, myErrors.ErrKeyAlreadyExists, "negativ test: same short URL")
+
+	assert.Equal(t, "short", store.GetShortURL(ctx, "https://full.ru"))
+	assert.Empty(t, store.GetShortURL(ctx, "https://other.ru"))
+
+	fullURL, deleted, err := store.GetFullURL(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "https://full.ru", fullURL)
+	assert.False(t, deleted)
+	_, _, err = store.GetFullURL(ctx, "unknown")
+	assert.Error(t, err, "negativ test: unknown short URL")
+	expiresAt, err := store.GetExpiration(ctx, "short")
+	require.NoError(t, err)
+	assert.True(t, expiresAt.IsZero(), "url never expires")
+}
+
+func testBatch(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "saved", "https://saved.ru", "user", models.URLMeta{}))
+
+	tests := []struct {
+		name string
+		urls map[string]string
+		want error
+	}{
+		{
+			name: "negativ test: short URL is saved",
+			urls: map[string]string{"new1": "https://new1.ru", "saved": "https://new2.ru"},
+			want: myErrors.ErrKeyAlreadyExists,
+		},
+		{
+			name: "negativ test: full URL is saved",
+			urls: map[string]string{"new1": "https://new1.ru", "new2": "https://saved.ru"},
+			want: myErrors.ErrURLAlreadySaved,
+		},
+		{
+			name: "negativ test: full URL is repeated",
+			urls: map[string]string{"new1": "https://new1.ru", "new2": "https://new1.ru"},
+			want: myErrors.ErrURLAlreadySaved,
+		},
+	}
+	for _, tt := range tests {
+		err := store.SaveURLBatch(ctx, tt.urls, "user", nil)
+		assert.ErrorIs(t, err, tt.want, tt.name)
+		for shortURL := range tt.urls {
+			if shortURL != "saved" {
+				_, _, err := store.GetFullURL(ctx, shortURL)
+				assert.Error(t, err, "%s: batch is saved partially", tt.name)
+			}
+		}
+	}
+
+	urls := map[string]string{"new1": "https://new1.ru", "new2": "https://new2.ru"}
+	require.NoError(t, store.SaveURLBatch(ctx, urls, "user", nil), "positive test")
+	for shortURL, fullURL := range urls {
+		assert.Equal(t, shortURL, store.GetShortURL(ctx, fullURL))
+	}
+	saved, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: "user"})
+	require.NoError(t, err)
+	assert.Len(t, saved, 3)
+}
+
+func testDeleteOwnership(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "mine", "https://mine.ru", "user1", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "foreign", "https://foreign.ru", "user2", models.URLMeta{}))
+
+	require.NoError(t, store.SetDeletedFlag(ctx, "user1", []string{"mine", "foreign", "unknown"}))
+
+	_, deleted, err := store.GetFullURL(ctx, "mine")
+	require.NoError(t, err)
+	assert.True(t, deleted, "positive test: own url")
+	_, deleted, err = store.GetFullURL(ctx, "foreign")
+	require.NoError(t, err)
+	assert.False(t, deleted, "negativ test: url of another user")
+
+	require.NoError(t, store.SetDeletedFlag(ctx, "user1", []string{"mine"}), "deleting twice")
+}
+
+func testDeletedVisibility(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "live", "https://live.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "gone", "https://gone.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SetDeletedFlag(ctx, "user", []string{"gone"}))
+
+	fullURL, deleted, err := store.GetFullURL(ctx, "gone")
+	require.NoError(t, err)
+	assert.Equal(t, "https://gone.ru", fullURL)
+	assert.True(t, deleted)
+	assert.Equal(t, "gone", store.GetShortURL(ctx, "https://gone.ru"), "deleted url keeps its full URL")
+
+	all, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: "user"})
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	for _, url := range all {
+		assert.Equal(t, url.ShortURL == "gone", url.Deleted, url.ShortURL)
+	}
+
+	for _, flag := range []bool{false, true} {
+		flag := flag
+		urls, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: "user", Deleted: &flag})
+		require.NoError(t, err)
+		require.Len(t, urls, 1)
+		assert.Equal(t, flag, urls[0].Deleted)
+	}
+}
+
+func testExpiration(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "expired", "https://expired.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "later", "https://later.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SetExpiration(ctx, "expired", time.Now().Add(-time.Minute)))
+	require.NoError(t, store.SetExpiration(ctx, "later", time.Now().Add(time.Hour)))
+	assert.Error(t, store.SetExpiration(ctx, "unknown", time.Now()), "negativ test: unknown short URL")
+
+	expiresAt, err := store.GetExpiration(ctx, "later")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+	_, err = store.GetExpiration(ctx, "unknown")
+	assert.ErrorIs(t, err, myErrors.ErrURLNotFound, "negativ test: unknown short URL")
+
+	_, _, err = store.GetFullURL(ctx, "expired")
+	assert.ErrorIs(t, err, myErrors.ErrURLExpired)
+	_, _, err = store.GetFullURL(ctx, "later")
+	assert.NoError(t, err)
+
+	flagged, err := store.FlagExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), flagged)
+	flagged, err = store.FlagExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), flagged, "expired urls are flagged once")
+
+	deleted := true
+	urls, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: "user", Deleted: &deleted})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "expired", urls[0].ShortURL)
+
+	soon := time.Now().Add(time.Hour)
+	require.NoError(t, store.SaveURL(ctx, "saved-expiring", "https://saved-expiring.ru", "user",
+		models.URLMeta{ExpiresAt: soon}))
+	require.NoError(t, store.SaveURLBatch(ctx, map[string]string{
+		"batch-expiring": "https://batch-expiring.ru",
+		"batch-forever":  "https://batch-forever.ru",
+	}, "user", map[string]models.URLMeta{"batch-expiring": {ExpiresAt: soon}}))
+	for shortURL, want := range map[string]time.Time{
+		"saved-expiring": soon,
+		"batch-expiring": soon,
+		"batch-forever":  {},
+	} {
+		expiresAt, err := store.GetExpiration(ctx, shortURL)
+		require.NoError(t, err)
+		assert.WithinDuration(t, want, expiresAt, time.Second, "expiration is saved with the url %s", shortURL)
+	}
+}
+
+func testTitleAndTags(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "short", "https://full.ru", "user", models.URLMeta{}))
+
+	require.NoError(t, store.SetTitle(ctx, "short", "Title"))
+	require.NoError(t, store.SetTags(ctx, "user", "short", []string{"home", "work/reports"}))
+	assert.ErrorIs(t, store.SetTitle(ctx, "unknown", "Title"), myErrors.ErrURLNotFound)
+	assert.ErrorIs(t, store.SetTags(ctx, "user", "unknown", []string{"home"}), myErrors.ErrURLNotFound)
+	assert.ErrorIs(t, store.SetTags(ctx, "other", "short", []string{"stolen"}), myErrors.ErrURLNotFound,
+		"negativ test: url of another user")
+
+	urls, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: "user", Tag: "work"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "Title", urls[0].Title)
+	assert.Equal(t, []string{"home", "work/reports"}, urls[0].Tags)
+	assert.False(t, urls[0].UpdatedAt.Before(urls[0].CreatedAt))
+
+	require.NoError(t, store.SetTags(ctx, "user", "short", nil))
+	urls, err = store.GetURLByUserID(ctx, models.URLQuery{UserID: "user", Tag: "home"})
+	require.NoError(t, err)
+	assert.Empty(t, urls, "tags are replaced")
+
+	require.NoError(t, store.SaveURL(ctx, "saved-titled", "https://saved-titled.ru", "meta",
+		models.URLMeta{Title: "Saved", Tags: []string{"saved"}}))
+	require.NoError(t, store.SaveURLBatch(ctx, map[string]string{
+		"batch-titled": "https://batch-titled.ru",
+		"batch-plain":  "https://batch-plain.ru",
+	}, "meta", map[string]models.URLMeta{"batch-titled": {Title: "Batch", Tags: []string{"batch", "saved"}}}))
+	urls, err = store.GetURLByUserID(ctx, models.URLQuery{UserID: "meta"})
+	require.NoError(t, err)
+	titles := make(map[string]string, len(urls))
+	for _, url := range urls {
+		titles[url.ShortURL] = url.Title
+	}
+	assert.Equal(t, map[string]string{"saved-titled": "Saved", "batch-titled": "Batch", "batch-plain": ""}, titles,
+		"title is saved with the url")
+
+	urls, err = store.GetURLByUserID(ctx, models.URLQuery{UserID: "meta", Tag: "saved"})
+	require.NoError(t, err)
+	assert.Len(t, urls, 2, "tags are saved with the url")
+	urls, err = store.GetURLByUserID(ctx, models.URLQuery{UserID: "meta", Tag: "batch"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, []string{"batch", "saved"}, urls[0].Tags)
+}
+
+func testPages(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	for n := 0; n < pageURLs; n++ {
+		shortURL := fmt.Sprintf("page%d", n)
+		require.NoError(t, store.SaveURL(ctx, shortURL, "https://"+shortURL+".ru", "user", models.URLMeta{}))
+	}
+	require.NoError(t, store.SaveURL(ctx, "foreign", "https://foreign.ru", "other", models.URLMeta{}))
+
+	for _, desc := range []bool{false, true} {
+		all, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: "user", Desc: desc})
+		require.NoError(t, err)
+		require.Len(t, all, pageURLs)
+
+		paged := make([]models.UsersURLs, 0, pageURLs)
+		query := models.URLQuery{UserID: "user", Desc: desc, Limit: pageLimit}
+		for {
+			page, err := store.GetURLByUserID(ctx, query)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), pageLimit)
+			paged = append(paged, page...)
+			if len(page) < pageLimit {
+				break
+			}
+			last := page[len(page)-1]
+			query.After = &models.URLCursor{CreatedAt: last.CreatedAt, ShortURL: last.ShortURL}
+		}
+
+		require.Len(t, paged, pageURLs, "desc=%v", desc)
+		for i := range all {
+			assert.Equal(t, all[i].ShortURL, paged[i].ShortURL, "desc=%v", desc)
+		}
+	}
+}
+
+func testDomain(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "shop", "https://user@My-Shop.ru:8080/yandex", "user", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "yandex", "https://yandex.ru", "user", models.URLMeta{}))
+
+	tests := []struct {
+		domain    string
+		shortURLs []string
+	}{
+		{domain: "shop", shortURLs: []string{"shop"}},
+		{domain: "yandex", shortURLs: []string{"yandex"}},
+		{domain: "y_ndex", shortURLs: []string{}},
+		{domain: "%", shortURLs: []string{}},
+	}
+	for _, tt := range tests {
+		urls, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: "user", Domain: tt.domain})
+		require.NoError(t, err)
+		shortURLs := make([]string, 0, len(urls))
+		for _, url := range urls {
+			shortURLs = append(shortURLs, url.ShortURL)
+		}
+		assert.Equal(t, tt.shortURLs, shortURLs, "domain %q is matched literally", tt.domain)
+	}
+}
+
+func testClicks(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "clicked", "https://clicked.ru", "user", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "quiet", "https://quiet.ru", "user", models.URLMeta{}))
+
+	day := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.SaveClicks(ctx, []models.Click{
+		{ShortURL: "clicked", Time: day},
+		{ShortURL: "clicked", Time: day.Add(time.Hour)},
+		{ShortURL: "clicked", Time: day.Add(24 * time.Hour)},
+	}))
+
+	stats, err := store.GetClickStats(ctx, "clicked")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, []models.DayClicks{{Day: "2024-03-01", Clicks: 2}, {Day: "2024-03-02", Clicks: 1}}, stats.Days)
+
+	stats, err = store.GetClickStats(ctx, "quiet")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Total)
+	assert.Empty(t, stats.Days)
+
+	clicks := make(map[string]int64)
+	require.NoError(t, store.ExportURLs(ctx, "user", func(url models.ExportedURL) error {
+		require.NotNil(t, url.Clicks)
+		clicks[url.ShortURL] = *url.Clicks
+		return nil
+	}))
+	assert.Equal(t, map[string]int64{"clicked": 3, "quiet": 0}, clicks)
+
+	stop := errors.New("stop")
+	err = store.ExportURLs(ctx, "user", func(models.ExportedURL) error { return stop })
+	assert.ErrorIs(t, err, stop, "negativ test: export stops on the first error")
+}
+
+func testCounts(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.SaveURL(ctx, "one", "https://one.ru", "user1", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "two", "https://two.ru", "user1", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "three", "https://three.ru", "user2", models.URLMeta{}))
+	require.NoError(t, store.SaveURL(ctx, "anonymous", "https://anonymous.ru", "", models.URLMeta{}))
+
+	count, err := store.GetURLsCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	users, err := store.GetUsersCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users, "anonymous urls have no user")
+	assert.NoError(t, store.GetPing(ctx))
+}
+
+func testConcurrentSaves(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for u := 0; u < concurrentUsers; u++ {
+		userID := fmt.Sprintf("user%d", u)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < concurrentPerUser; n++ {
+				shortURL := fmt.Sprintf("%s-%d", userID, n)
+				assert.NoError(t, store.SaveURL(ctx, shortURL, "https://"+shortURL+".ru", userID, models.URLMeta{}))
+				assert.NoError(t, store.SetDeletedFlag(ctx, userID, []string{shortURL}))
+				_, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: userID, Limit: pageLimit})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+
+	var saved atomic.Int64
+	for c := 0; c < contenders; c++ {
+		shortURL := fmt.Sprintf("contender%d", c)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.SaveURL(ctx, shortURL, "https://contended.ru", "contender", models.URLMeta{})
+			if err == nil {
+				saved.Add(1)
+				return
+			}
+			assert.ErrorIs(t, err, myErrors.ErrURLAlreadySaved)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), saved.Load(), "one of the same full URLs is saved")
+	count, err := store.GetURLsCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, concurrentUsers*concurrentPerUser+1, count)
+	for u := 0; u < concurrentUsers; u++ {
+		urls, err := store.GetURLByUserID(ctx, models.URLQuery{UserID: fmt.Sprintf("user%d", u)})
+		require.NoError(t, err)
+		assert.Len(t, urls, concurrentPerUser)
+		for _, url := range urls {
+			assert.True(t, url.Deleted, url.ShortURL)
+		}
+	}
+}
+
+func testLeaseIDs(t *testing.T, store storage.Store) {
+	const leaseCount = 10
+
+	ctx := context.Background()
+	starts := make(chan uint64, contenders)
+	var wg sync.WaitGroup
+	for c := 0; c < contenders; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start, err := store.LeaseIDs(ctx, leaseCount)
+			assert.NoError(t, err)
+			starts <- start
+		}()
+	}
+	wg.Wait()
+	close(starts)
+
+	seen := make(map[uint64]struct{}, contenders*leaseCount)
+	for start := range starts {
+		assert.NotZero(t, start)
+		for id := start; id < start+leaseCount; id++ {
+			seen[id] = struct{}{}
+		}
+	}
+	assert.Len(t, seen, contenders*leaseCount, "leases don't overlap")
+}